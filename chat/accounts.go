@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// limits for /register; bcrypt ignores everything past 72 bytes
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72
+)
+
+var errNameRegistered = errors.New("name already registered")
+
+// bcrypt work factor for new passwords; tests lower it
+var bcryptCost = bcrypt.DefaultCost
+
+// accountStore is the registry of reserved names and their bcrypt hashes,
+// kept in a JSON file. It has its own mutex so the slow hashing never runs
+// under lockClients; when both are needed, lockClients is taken first.
+type accountStore struct {
+	mu     sync.Mutex
+	path   string            // empty until LoadAccounts; registration is off
+	hashes map[string]string // name -> bcrypt hash
+}
+
+// LoadAccounts turns on registered accounts, kept in the JSON file at path.
+// A missing file is an empty registry; the file is created on the first
+// /register. Call it before Serve.
+func (s *Server) LoadAccounts(path string) error {
+	hashes := make(map[string]string)
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(b, &hashes); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	s.accounts.mu.Lock()
+	s.accounts.path, s.accounts.hashes = path, hashes
+	s.accounts.mu.Unlock()
+	return nil
+}
+
+func (a *accountStore) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.path != ""
+}
+
+// registered reports whether name is reserved by an account.
+func (a *accountStore) registered(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.hashes[name]
+	return ok
+}
+
+// check reports whether password opens the account called name. The hash
+// comparison runs outside the mutex.
+func (a *accountStore) check(name, password string) bool {
+	a.mu.Lock()
+	hash, ok := a.hashes[name]
+	a.mu.Unlock()
+	return ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// add registers name with an already computed hash and saves the registry,
+// undoing the change if the file can't be written.
+func (a *accountStore) add(name string, hash []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.hashes[name]; ok {
+		return errNameRegistered
+	}
+	a.hashes[name] = string(hash)
+	if err := a.saveLocked(); err != nil {
+		delete(a.hashes, name)
+		return err
+	}
+	return nil
+}
+
+// saveLocked writes the registry to a temporary file and renames it over
+// the old one, so a crash can't leave it half written. Caller must hold mu.
+func (a *accountStore) saveLocked() error {
+	b, err := json.MarshalIndent(a.hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), ".accounts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.path)
+}
+
+// registerAccount handles "/register <name> <password>": it reserves name for the
+// caller and logs them in as it. Nobody else may be online under that name.
+func (s *Server) registerAccount(clientID int, args string) error {
+	if !s.accounts.enabled() {
+		return s.sendTo(clientID, "Accounts are not enabled on this server.\n")
+	}
+	name, password, _ := strings.Cut(strings.TrimSpace(args), " ")
+	password = strings.TrimSpace(password)
+	if name == "" || password == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("register")+" <name> <password>\n")
+	}
+	if name != truncateRunes(name, s.config().MaxNameRunes) || nameProblem(name, s.config().CommandPrefix) != "" {
+		return s.sendTo(clientID, "That name can't be registered.\n")
+	}
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return s.sendTo(clientID, fmt.Sprintf("Passwords must be %d to %d bytes long.\n", minPasswordLen, maxPasswordLen))
+	}
+	if s.accounts.registered(name) {
+		return s.sendTo(clientID, name+" is already registered.\n")
+	}
+	if s.nameHeldByOther(clientID, name) {
+		return s.sendTo(clientID, "Someone else is online as "+name+".\n")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return s.sendTo(clientID, "Registration failed.\n")
+	}
+	switch err := s.accounts.add(name, hash); {
+	case errors.Is(err, errNameRegistered):
+		return s.sendTo(clientID, name+" is already registered.\n")
+	case err != nil:
+		fmt.Println("accounts:", err)
+		return s.sendTo(clientID, "Registration failed.\n")
+	}
+	s.takeName(clientID, name)
+	return s.sendTo(clientID, "Registered "+name+"; you are logged in as "+name+". Next time, log in with "+s.cmdRef("login")+" "+name+" <password>.\n")
+}
+
+// loginAccount handles "/login <name> <password>" after login, switching the
+// caller to a registered name. At the username prompt awaitName handles
+// it instead.
+func (s *Server) loginAccount(clientID int, args string) error {
+	if !s.accounts.enabled() {
+		return s.sendTo(clientID, "Accounts are not enabled on this server.\n")
+	}
+	name, password, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if name == "" || password == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("login")+" <name> <password>\n")
+	}
+	if !s.accounts.check(name, strings.TrimSpace(password)) {
+		return s.sendTo(clientID, "Login failed.\n")
+	}
+	s.takeName(clientID, name)
+	return s.sendTo(clientID, "You are logged in as "+name+".\n")
+}
+
+// nameHeldByOther reports whether a client other than clientID is online
+// as name.
+func (s *Server) nameHeldByOther(clientID int, name string) bool {
+	s.lockClients.RLock()
+	defer s.lockClients.RUnlock()
+	for _, c := range s.findClientsLocked(name) {
+		if c.ID != clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// takeName renames a logged-in client, keeping its resume session in step.
+func (s *Server) takeName(clientID int, name string) {
+	s.lockClients.Lock()
+	defer s.lockClients.Unlock()
+	if c := s.idToClient[clientID]; c != nil {
+		c.Name = name
+		if own := s.sessions[c.resumeToken]; own != nil {
+			own.name = name
+		}
+	}
+}
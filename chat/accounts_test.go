@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	bcryptCost = bcrypt.MinCost
+}
+
+func newAccountServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s := newTestServer(t)
+	if err := s.LoadAccounts(path); err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestRegisterReservesName(t *testing.T) {
+	s, path := newAccountServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/register bob longpassword")
+	alice.expect("Someone else is online as bob.\n")
+	alice.send("/register alice short")
+	alice.expect("Passwords must be 8 to 72 bytes long.\n")
+	alice.send("/register alice correct horse")
+	alice.expect("Registered alice; you are logged in as alice. Next time, log in with /login alice <password>.\n")
+	bob.send("/register alice whatever123")
+	bob.expect("alice is already registered.\n")
+
+	// the name can't be taken without the password, even at the prompt
+	imp := dial(t, s)
+	imp.expect("Please enter your username: ")
+	imp.send("alice")
+	imp.expect("alice is a registered name; use /login alice <password>. Please enter your username: ")
+	imp.send("/login alice wrong")
+	imp.expect("Login failed. Please enter your username: ")
+	imp.send("/login alice correct horse")
+	imp.expect("Welcome alice!")
+
+	// the registry survives a restart
+	s2 := newTestServer(t)
+	if err := s2.LoadAccounts(path); err != nil {
+		t.Fatal(err)
+	}
+	if !s2.accounts.check("alice", "correct horse") || s2.accounts.check("alice", "correct") {
+		t.Fatal("reloaded registry doesn't check passwords")
+	}
+	carol := connect(t, s2, "carol")
+	carol.send("/login alice correct horse")
+	carol.expect("You are logged in as alice.\n")
+	carol.send("/users")
+	carol.expect("1. alice\n")
+}
+
+func TestAccountsDisabled(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	alice.send("/register alice longpassword")
+	alice.expect("Accounts are not enabled on this server.\n")
+	alice.send("/login alice longpassword")
+	alice.expect("Accounts are not enabled on this server.\n")
+}
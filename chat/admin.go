@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// maxAuthFailures is how many wrong /auth passwords one connection may
+// send; the next is answered by hanging up, so guessing needs a fresh
+// connection (and login) every few tries.
+const maxAuthFailures = 3
+
+// errAuthFailures ends a session that guessed too many /auth passwords.
+var errAuthFailures = errors.New("too many failed /auth attempts")
+
+// auth makes the caller an admin if password matches Config.AdminPass.
+// After maxAuthFailures wrong passwords the connection is closed.
+func (s *Server) auth(clientID int, password string) error {
+	pass := s.config().AdminPass
+	if pass == "" {
+		return s.sendTo(clientID, "Admin commands are disabled on this server.\n")
+	}
+	if password == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("auth")+" <password>\n")
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(pass)) != 1 {
+		s.lockClients.Lock()
+		failures := 0
+		if c := s.idToClient[clientID]; c != nil {
+			c.authFailures++
+			failures = c.authFailures
+		}
+		s.lockClients.Unlock()
+		if failures >= maxAuthFailures {
+			_ = s.sendToWait(clientID, "Not authorized. Too many failed attempts; disconnecting.\n")
+			return errAuthFailures
+		}
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+
+	s.lockClients.Lock()
+	if c := s.idToClient[clientID]; c != nil {
+		c.IsAdmin = true
+	}
+	s.lockClients.Unlock()
+	return s.sendTo(clientID, "You are now an admin.\n")
+}
+
+// isAdmin reports whether clientID has passed /auth.
+func (s *Server) isAdmin(clientID int) bool {
+	s.lockClients.RLock()
+	defer s.lockClients.RUnlock()
+	c := s.idToClient[clientID]
+	return c != nil && c.IsAdmin
+}
+
+// announce sends text to every logged-in client, whatever group they are in,
+// the sender included.
+func (s *Server) announce(clientID int, text string) error {
+	if !s.isAdmin(clientID) {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("announce")+" <text>\n")
+	}
+	s.announceAll(text)
+	return nil
+}
+
+// createGroup handles the admin command "/create <group> [keep]": it adds
+// an empty group without joining it, so it shows in /groups and can be
+// joined under Config.StrictGroups. The first to join owns it. Like any
+// group it goes away when its last member leaves, unless "keep" made it
+// permanent, as the groups from Config.Groups are.
+func (s *Server) createGroup(clientID int, args string) error {
+	if !s.isAdmin(clientID) {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+	group, opt, _ := strings.Cut(strings.TrimSpace(args), " ")
+	opt = strings.TrimSpace(opt)
+	if group == "" || (opt != "" && opt != "keep") {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("create")+" <group> [keep]\n")
+	}
+	if problem := groupNameProblem(group); problem != "" {
+		return s.sendTo(clientID, problem)
+	}
+
+	cfg := s.config()
+	s.lockClients.Lock()
+	_, exists := s.groupsToClient[group]
+	var reply string
+	switch {
+	case exists:
+		reply = "Group " + group + " already exists.\n"
+	case cfg.MaxGroups > 0 && len(s.groupsToClient) >= cfg.MaxGroups:
+		reply = fmt.Sprintf("The server already has the maximum of %d groups.\n", cfg.MaxGroups)
+	default:
+		s.groupsToClient[group] = []int{}
+		// not "Created group ...": clients read that as having joined
+		reply = "Group " + group + " is ready; you have not joined it.\n"
+		if opt == "keep" {
+			s.fixedGroups[group] = true
+			reply = "Group " + group + " is ready and stays when empty; you have not joined it.\n"
+		}
+	}
+	s.lockClients.Unlock()
+	return s.sendTo(clientID, reply)
+}
+
+// listAll handles the admin command "/list": every logged-in client with
+// its ID and group, then every group with its member count. Both halves
+// come from one snapshot under the lock, so they agree with each other.
+func (s *Server) listAll(clientID int, _ string) error {
+	if !s.isAdmin(clientID) {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+
+	s.lockClients.RLock()
+	clients := make([]*Client, len(s.clientList))
+	copy(clients, s.clientList)
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	var b strings.Builder
+	fmt.Fprintf(&b, "Connected Users (%d):\n", len(clients))
+	for _, c := range clients {
+		fmt.Fprintf(&b, "#%d %s", c.ID, c.Name)
+		if c.Status != "" {
+			b.WriteString(" (" + c.Status + ")")
+		}
+		if groups := s.groupsOfLocked(c.ID); len(groups) > 0 {
+			b.WriteString(" in " + strings.Join(groups, ", "))
+		}
+		b.WriteString("\n")
+	}
+	groups := make([]string, 0, len(s.groupsToClient))
+	for grp := range s.groupsToClient {
+		groups = append(groups, grp)
+	}
+	sort.Strings(groups)
+	fmt.Fprintf(&b, "Groups (%d):\n", len(groups))
+	for _, grp := range groups {
+		fmt.Fprintf(&b, "%s (%d user/s)\n", grp, len(s.groupsToClient[grp]))
+	}
+	s.lockClients.RUnlock()
+
+	return s.sendTo(clientID, b.String())
+}
+
+// announceAll sends text as an announcement to every logged-in client. It
+// serves both /announce and the admin API, so it cleans the text itself:
+// line breaks become spaces, since an announcement is one line, and with
+// StripControl escape sequences go as they do from any other sender.
+func (s *Server) announceAll(text string) {
+	cfg := s.config()
+	text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }), " ")
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	out := protocol.AnnouncePrefix + truncateRunes(text, cfg.MaxMessageRunes) + "\n"
+
+	s.lockClients.RLock()
+	recipients := make([]int, 0, len(s.clientList))
+	for _, c := range s.clientList {
+		recipients = append(recipients, c.ID)
+	}
+	s.lockClients.RUnlock()
+
+	s.broadcast(recipients, out, -1)
+}
+
+// disconnect handles "/disconnect <id> [reason]": an admin drops one
+// connection by its ID (see /users -v or /id), which stays precise when
+// several users share a name. The target is told why before the server
+// hangs up, and the action is logged.
+func (s *Server) disconnect(clientID int, args string) error {
+	if !s.isAdmin(clientID) {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+	idStr, reason, _ := strings.Cut(strings.TrimSpace(args), " ")
+	targetID, err := strconv.Atoi(strings.TrimPrefix(idStr, "#"))
+	if err != nil {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("disconnect")+" <id> [reason]\n")
+	}
+	if targetID == clientID {
+		return s.sendTo(clientID, "Use "+s.cmdRef("quit")+" to leave.\n")
+	}
+
+	s.lockClients.RLock()
+	admin, target := s.idToClient[clientID], s.idToClient[targetID]
+	_, isPeer := s.peers[targetID]
+	var adminName, targetName string
+	if admin != nil && target != nil {
+		adminName, targetName = admin.Name, target.Name
+	}
+	s.lockClients.RUnlock()
+	if target == nil || isPeer {
+		return s.sendTo(clientID, fmt.Sprintf("No client has ID %d.\n", targetID))
+	}
+
+	notice := protocol.KickNotice + ".\n"
+	if reason = strings.TrimSpace(reason); reason != "" {
+		notice = protocol.KickNotice + ": " + reason + "\n"
+	}
+	_ = s.sendToWait(targetID, notice)
+	s.closeClient(targetID, errKicked)
+	fmt.Printf("admin %s (id %d) disconnected %s (id %d)\n", adminName, clientID, targetName, targetID)
+	return s.sendTo(clientID, fmt.Sprintf("Disconnected %s (id %d).\n", targetName, targetID))
+}
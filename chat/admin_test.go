@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"testing"
+
+	"chat-app-go/protocol"
+)
+
+func newAdminServer(t *testing.T) *Server {
+	cfg := DefaultConfig()
+	cfg.AdminPass = "hunter2"
+	return newTestServerWith(t, cfg)
+}
+
+func TestAnnounceReachesEveryone(t *testing.T) {
+	s := newAdminServer(t)
+	admin := connect(t, s, "admin")
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/announce restart at 5pm")
+	for _, tc := range []*testClient{admin, alice, bob} {
+		tc.expect(protocol.AnnouncePrefix + "restart at 5pm\n")
+	}
+}
+
+func TestAnnounceNeedsAuth(t *testing.T) {
+	s := newAdminServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/announce hi")
+	alice.expect("Not authorized.\n")
+	alice.send("/auth wrong")
+	alice.expect("Not authorized.\n")
+	alice.send("/announce hi")
+	alice.expect("Not authorized.\n")
+	bob.expectQuiet(protocol.AnnouncePrefix)
+}
+
+func TestAuthGuessesAreLimited(t *testing.T) {
+	s := newAdminServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	for range maxAuthFailures - 1 {
+		alice.send("/auth guess")
+		alice.expect("Not authorized.\n")
+	}
+	alice.send("/auth hunter2")
+	alice.expect("You are now an admin.\n")
+
+	for range maxAuthFailures - 1 {
+		bob.send("/auth guess")
+		bob.expect("Not authorized.\n")
+	}
+	bob.send("/auth guess")
+	bob.expect("Not authorized. Too many failed attempts; disconnecting.\n")
+	bob.expectClosed()
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+func TestAuthDisabledWithoutPassword(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/auth ")
+	alice.expect("Admin commands are disabled on this server.\n")
+	alice.send("/announce hi")
+	alice.expect("Not authorized.\n")
+}
+
+func TestVerboseUsersForAdmins(t *testing.T) {
+	s := newAdminServer(t)
+	admin := connect(t, s, "admin")
+	alice := connect(t, s, "alice")
+
+	alice.send("/users -v")
+	alice.expect("Connected Users:\n1. admin\n2. alice\n")
+
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/users -v")
+	admin.expect("Connected Users:\n1. admin (id 1, pipe)\n2. alice (id 2, pipe)\n")
+}
+
+func TestGroupMessageCrossPosts(t *testing.T) {
+	s := newAdminServer(t)
+	admin := connect(t, s, "admin")
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+
+	bob.send("/gmsg rust hello")
+	bob.expect("Not authorized.\n")
+	admin.send("/gmsg nope hello")
+	admin.expect("No such group\n")
+	admin.send("/gmsg rust")
+	admin.expect("Usage: /gmsg <group> <message>\n")
+
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/gmsg rust maintenance at noon")
+	admin.expect("[rust] (cross-post) admin: maintenance at noon\n")
+	alice.expect("[rust] (cross-post) admin: maintenance at noon\n")
+	bob.expectQuiet("cross-post")
+
+	alice.send("/gmsg rust mine")
+	alice.expect("[rust] (cross-post) alice: mine\n")
+}
+
+func TestDisconnectByID(t *testing.T) {
+	s := newAdminServer(t)
+	admin := connect(t, s, "admin")
+	bob1 := connect(t, s, "bob")
+	bob2 := connect(t, s, "bob")
+
+	bob1.send("/disconnect 3")
+	bob1.expect("Not authorized.\n")
+
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/disconnect bob")
+	admin.expect("Usage: /disconnect <id> [reason]\n")
+	admin.send("/disconnect 99")
+	admin.expect("No client has ID 99.\n")
+	admin.send("/disconnect 1")
+	admin.expect("Use /quit to leave.\n")
+
+	admin.send("/disconnect 3 spamming")
+	bob2.expect("[kicked] You have been disconnected by an admin: spamming\n")
+	bob2.expectClosed()
+	admin.expect("Disconnected bob (id 3).\n")
+	bob1.send("/users")
+	bob1.expect("Connected Users:\n1. admin\n2. bob\n")
+}
+
+func TestCreateGroup(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminPass = "hunter2"
+	cfg.StrictGroups = true
+	s := newTestServerWith(t, cfg)
+	admin := connect(t, s, "admin")
+	bob := connect(t, s, "bob")
+
+	bob.send("/create go")
+	bob.expect("Not authorized.\n")
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/create go")
+	admin.expect("Group go is ready; you have not joined it.\n")
+	admin.send("/create go")
+	admin.expect("Group go already exists.\n")
+	admin.send("/create rust keep")
+	admin.expect("Group rust is ready and stays when empty; you have not joined it.\n")
+	admin.send("/mygroups")
+	admin.expect("You are not part of any group.\n")
+
+	bob.send("/groups")
+	bob.expect("Available Groups:\ngo (0 user/s)\nrust (0 user/s)\n")
+	bob.send("/join go")
+	bob.expect("Successfully joined group go\n")
+	bob.send("/leave")
+	bob.expect("You have left the group go\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	bob.send("/leave")
+	bob.expect("You have left the group rust\n")
+
+	// only the kept group outlives its last member
+	bob.send("/groups")
+	bob.expect("Available Groups:\nrust (0 user/s)\n")
+}
+
+func TestListAll(t *testing.T) {
+	s := newAdminServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/status away")
+	bob.expect("Status set to: away\n")
+	bob.send("/list")
+	bob.expect("Not authorized.\n")
+	bob.send("/auth hunter2")
+	bob.expect("admin")
+	bob.send("/join go")
+	bob.expect("Created group go\n")
+
+	bob.send("/list")
+	bob.expect("Connected Users (3):\n" +
+		"#1 alice in rust\n" +
+		"#2 bob (away) in go\n" +
+		"#3 carol\n" +
+		"Groups (2):\n" +
+		"go (1 user/s)\n" +
+		"rust (1 user/s)\n")
+}
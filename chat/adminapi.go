@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// errKicked ends a session removed through the admin API.
+var errKicked = errors.New("kicked by an admin")
+
+// ClientInfo describes one logged-in client in the admin API.
+type ClientInfo struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Addr       string    `json:"addr"`
+	Version    string    `json:"client_version,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Admin      bool      `json:"admin"`
+	LastActive time.Time `json:"last_active"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+}
+
+// GroupInfo describes one group in the admin API.
+type GroupInfo struct {
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+	Members []string `json:"members"`
+}
+
+// AdminHandler serves a small JSON API for ops tools:
+//
+//	GET  /clients              logged-in clients
+//	GET  /groups               groups and their members
+//	POST /kick?name=<user>     disconnect a user (or ?id=<id> from /clients)
+//	POST /announce?text=<msg>  send an announcement to everyone
+//
+// Every request must carry "Authorization: Bearer <token>". Handlers copy
+// what they need under lockClients and release it before writing the
+// response or touching any connection.
+func (s *Server) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /clients", s.apiClients)
+	mux.HandleFunc("GET /groups", s.apiGroups)
+	mux.HandleFunc("POST /kick", s.apiKick)
+	mux.HandleFunc("POST /announce", s.apiAnnounce)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) apiClients(w http.ResponseWriter, _ *http.Request) {
+	s.lockClients.RLock()
+	clients := make([]ClientInfo, 0, len(s.clientList))
+	for _, c := range s.clientList {
+		clients = append(clients, ClientInfo{
+			ID:         c.ID,
+			Name:       c.Name,
+			Addr:       c.Addr,
+			Version:    c.ClientVersion,
+			Group:      s.clientToGroup[c.ID],
+			Status:     c.Status,
+			Admin:      c.IsAdmin,
+			LastActive: c.lastActive(),
+			BytesIn:    c.BytesIn.Load(),
+			BytesOut:   c.BytesOut.Load(),
+		})
+	}
+	s.lockClients.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (s *Server) apiGroups(w http.ResponseWriter, _ *http.Request) {
+	s.lockClients.RLock()
+	groups := make([]GroupInfo, 0, len(s.groupsToClient))
+	for name, ids := range s.groupsToClient {
+		g := GroupInfo{Name: name, Topic: s.groupTopic[name], Members: []string{}}
+		if c := s.idToClient[s.groupOwner[name]]; c != nil {
+			g.Owner = c.Name
+		}
+		for _, id := range ids {
+			if c := s.idToClient[id]; c != nil {
+				g.Members = append(g.Members, c.Name)
+			}
+		}
+		groups = append(groups, g)
+	}
+	s.lockClients.RUnlock()
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	for _, g := range groups {
+		sortNames(g.Members)
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func (s *Server) apiKick(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	idStr := strings.TrimSpace(r.FormValue("id"))
+	if name == "" && idStr == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name or id is required"})
+		return
+	}
+	s.lockClients.RLock()
+	id := -1
+	if idStr != "" {
+		if n, err := strconv.Atoi(idStr); err == nil {
+			if c := s.idToClient[n]; c != nil && slices.Contains(s.clientList, c) {
+				id, name = c.ID, c.Name
+			}
+		}
+	} else if found := s.findClientsLocked(name); len(found) == 1 {
+		id = found[0].ID
+	} else if len(found) > 1 {
+		s.lockClients.RUnlock()
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "several users have that name; kick by id"})
+		return
+	}
+	s.lockClients.RUnlock()
+	if id < 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such user"})
+		return
+	}
+
+	_ = s.sendToWait(id, protocol.KickNotice+".\n")
+	s.closeClient(id, errKicked)
+	writeJSON(w, http.StatusOK, map[string]string{"kicked": name})
+}
+
+func (s *Server) apiAnnounce(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "text is required"})
+		return
+	}
+	s.announceAll(text)
+	writeJSON(w, http.StatusOK, map[string]string{"announced": text})
+}
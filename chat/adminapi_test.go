@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"chat-app-go/protocol"
+)
+
+func apiRequest(t *testing.T, h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAPIAuth(t *testing.T) {
+	s := newTestServer(t)
+	h := s.AdminHandler("tok")
+
+	if rec := apiRequest(t, h, "GET", "/clients", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", rec.Code)
+	}
+	if rec := apiRequest(t, h, "GET", "/clients", "nope"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", rec.Code)
+	}
+	if rec := apiRequest(t, s.AdminHandler(""), "GET", "/clients", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("empty configured token: status %d", rec.Code)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	s := newTestServer(t)
+	h := s.AdminHandler("tok")
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+
+	var clients []ClientInfo
+	rec := apiRequest(t, h, "GET", "/clients", "tok")
+	if err := json.Unmarshal(rec.Body.Bytes(), &clients); err != nil || len(clients) != 2 {
+		t.Fatalf("GET /clients = %d %s", rec.Code, rec.Body)
+	}
+	if clients[0].Name != "alice" || clients[0].Group != "rust" || clients[1].Name != "bob" {
+		t.Errorf("clients = %+v", clients)
+	}
+
+	var groups []GroupInfo
+	rec = apiRequest(t, h, "GET", "/groups", "tok")
+	if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil || len(groups) != 1 {
+		t.Fatalf("GET /groups = %d %s", rec.Code, rec.Body)
+	}
+	if g := groups[0]; g.Name != "rust" || g.Owner != "alice" || len(g.Members) != 1 {
+		t.Errorf("groups = %+v", groups)
+	}
+
+	rec = apiRequest(t, h, "POST", "/announce?text=maintenance", "tok")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /announce = %d %s", rec.Code, rec.Body)
+	}
+	alice.expect(protocol.AnnouncePrefix + "maintenance\n")
+	bob.expect(protocol.AnnouncePrefix + "maintenance\n")
+
+	// escape sequences are stripped and a line break can't start a forged line
+	rec = apiRequest(t, h, "POST", "/announce?text=%1B%5B2Jwake%0A%5Bkicked%5D+up", "tok")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /announce = %d %s", rec.Code, rec.Body)
+	}
+	alice.expect(protocol.AnnouncePrefix + "wake [kicked] up\n")
+
+	if rec = apiRequest(t, h, "POST", "/kick?name=carol", "tok"); rec.Code != http.StatusNotFound {
+		t.Errorf("kick unknown user: status %d", rec.Code)
+	}
+	other := connect(t, s, "bob")
+	if rec = apiRequest(t, h, "POST", "/kick?name=bob", "tok"); rec.Code != http.StatusConflict {
+		t.Errorf("kick shared name: status %d", rec.Code)
+	}
+	s.lockClients.RLock()
+	otherID := s.findClientsLocked("bob")[1].ID
+	s.lockClients.RUnlock()
+	if rec = apiRequest(t, h, "POST", "/kick?id="+strconv.Itoa(otherID), "tok"); rec.Code != http.StatusOK {
+		t.Fatalf("POST /kick?id = %d %s", rec.Code, rec.Body)
+	}
+	other.expectClosed()
+	if rec = apiRequest(t, h, "POST", "/kick?name=bob", "tok"); rec.Code != http.StatusOK {
+		t.Fatalf("POST /kick = %d %s", rec.Code, rec.Body)
+	}
+	bob.expect("[kicked] You have been disconnected by an admin.\n")
+	bob.expectClosed()
+}
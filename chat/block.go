@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"sort"
+	"strings"
+)
+
+// blockUser handles "/block [user [dm]]". A blocked user's DMs, typing
+// notices and chat lines stop reaching the caller; with "dm" only their DMs
+// and DM typing notices do. Blocks are by name and last for the session.
+// Without arguments it lists the caller's blocks.
+func (s *Server) blockUser(clientID int, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return s.listBlocks(clientID)
+	}
+	if len(fields) > 2 || (len(fields) == 2 && strings.ToLower(fields[1]) != "dm") {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("block")+" <user> [dm]\n")
+	}
+	target, dmOnly := fields[0], len(fields) == 2
+
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	if c == nil {
+		s.lockClients.Unlock()
+		return errClientGone
+	}
+	if target == c.Name {
+		s.lockClients.Unlock()
+		return s.sendTo(clientID, "You can't block yourself.\n")
+	}
+	if c.blocked == nil {
+		c.blocked = make(map[string]bool)
+	}
+	c.blocked[target] = dmOnly
+	s.lockClients.Unlock()
+
+	if dmOnly {
+		return s.sendTo(clientID, "Blocked direct messages from "+target+".\n")
+	}
+	return s.sendTo(clientID, "Blocked "+target+".\n")
+}
+
+// unblockUser handles "/unblock <user>".
+func (s *Server) unblockUser(clientID int, args string) error {
+	target := strings.TrimSpace(args)
+	if target == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("unblock")+" <user>\n")
+	}
+	s.lockClients.Lock()
+	was := false
+	if c := s.idToClient[clientID]; c != nil {
+		_, was = c.blocked[target]
+		delete(c.blocked, target)
+	}
+	s.lockClients.Unlock()
+
+	if !was {
+		return s.sendTo(clientID, target+" is not blocked.\n")
+	}
+	return s.sendTo(clientID, "Unblocked "+target+".\n")
+}
+
+func (s *Server) listBlocks(clientID int) error {
+	s.lockClients.RLock()
+	var names []string
+	if c := s.idToClient[clientID]; c != nil {
+		for name, dmOnly := range c.blocked {
+			if dmOnly {
+				name += " (DMs only)"
+			}
+			names = append(names, name)
+		}
+	}
+	s.lockClients.RUnlock()
+
+	if len(names) == 0 {
+		return s.sendTo(clientID, "You haven't blocked anyone.\n")
+	}
+	sort.Strings(names)
+	return s.sendTo(clientID, "Blocked: "+strings.Join(names, ", ")+"\n")
+}
+
+// blocksLocked reports whether c has blocked sender for a DM (dm set) or a
+// chat line. Caller must hold lockClients.
+func (c *Client) blocksLocked(sender string, dm bool) bool {
+	dmOnly, ok := c.blocked[sender]
+	return ok && (dm || !dmOnly)
+}
@@ -0,0 +1,42 @@
+package chat
+
+import "testing"
+
+func TestBlockSuppressesMessages(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Acks = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/block")
+	alice.expect("You haven't blocked anyone.\n")
+	alice.send("/block alice")
+	alice.expect("You can't block yourself.\n")
+	alice.send("/block bob")
+	alice.expect("Blocked bob.\n")
+	alice.send("/block carol dm")
+	alice.expect("Blocked direct messages from carol.\n")
+	alice.send("/block")
+	alice.expect("Blocked: bob, carol (DMs only)\n")
+
+	// the sender sees the same reply as for a delivered DM
+	bob.send("/msg alice psst")
+	bob.expect("Delivered to alice.\n")
+	carol.send("/msg alice psst")
+	carol.expect("Delivered to alice.\n")
+	bob.send("hello everyone")
+	carol.expect("[Global] bob: hello everyone\n")
+	carol.send("hi all")
+	alice.expect("[Global] carol: hi all\n")
+	alice.expectQuiet("psst")
+	alice.expectQuiet("bob")
+
+	alice.send("/unblock bob")
+	alice.expect("Unblocked bob.\n")
+	alice.send("/unblock bob")
+	alice.expect("bob is not blocked.\n")
+	bob.send("/msg alice again")
+	alice.expect("[DM] bob: again\n")
+}
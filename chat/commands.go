@@ -0,0 +1,543 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// getUsersList sends the caller's group roster, or every connected user when
+// ungrouped. Names are sorted so the output is stable across calls. Admins
+// can ask for "/users -v" to see each client's ID and remote address too.
+func (s *Server) getUsersList(clientID int, args string) error {
+	verbose := strings.TrimSpace(args) == "-v" && s.isAdmin(clientID)
+
+	s.lockClients.RLock()
+	var header string
+	var members []*Client
+	if groupName, ok := s.clientToGroup[clientID]; !ok {
+		header = "Connected Users:"
+		members = append(members, s.clientList...)
+	} else {
+		header = "Users connected to " + groupName + ":"
+		for _, id := range s.groupsToClient[groupName] {
+			if c := s.idToClient[id]; c != nil {
+				members = append(members, c)
+			}
+		}
+	}
+	names := make([]string, 0, len(members))
+	for _, c := range members {
+		name := c.Name
+		if c.Status != "" {
+			name += " (" + c.Status + ")"
+		}
+		if verbose {
+			name += fmt.Sprintf(" (id %d, %s)", c.ID, c.Addr)
+		}
+		names = append(names, name)
+	}
+	s.lockClients.RUnlock()
+
+	sortNames(names)
+	usersList := header
+	for j, name := range names {
+		usersList += "\n" + fmt.Sprintf("%d. %s", j+1, name)
+	}
+	return s.sendTo(clientID, usersList+"\n")
+}
+
+func (s *Server) ping(clientID int, token string) error {
+	return s.sendTo(clientID, protocol.PongPrefix+truncateRunes(token, 64)+"\n")
+}
+
+func (s *Server) stats(clientID int) error {
+	s.lockClients.RLock()
+	clients, groups := len(s.clientList), len(s.groupsToClient)
+	var yourIn, yourOut int64
+	if c := s.idToClient[clientID]; c != nil {
+		yourIn, yourOut = c.BytesIn.Load(), c.BytesOut.Load()
+	}
+	s.lockClients.RUnlock()
+
+	out := "Server stats:\n" +
+		"Uptime: " + time.Since(s.started).Round(time.Second).String() + "\n" +
+		fmt.Sprintf("Connections handled: %d\n", s.totalConns.Load()) +
+		fmt.Sprintf("Connected clients: %d\n", clients) +
+		fmt.Sprintf("Groups: %d\n", groups) +
+		fmt.Sprintf("Traffic: %d bytes in, %d bytes out\n", s.bytesIn.Load(), s.bytesOut.Load()) +
+		fmt.Sprintf("Your traffic: %d bytes in, %d bytes out\n", yourIn, yourOut)
+	return s.sendTo(clientID, out)
+}
+
+// myStats handles "/mystats": the caller's own traffic since they
+// connected, from the same per-client counters the admin API reports.
+func (s *Server) myStats(clientID int, _ string) error {
+	s.lockClients.RLock()
+	c := s.idToClient[clientID]
+	s.lockClients.RUnlock()
+	if c == nil {
+		return errClientGone
+	}
+
+	out := "Your session:\n" +
+		"Connected for: " + time.Since(c.Connected).Round(time.Second).String() + "\n" +
+		fmt.Sprintf("Sent: %d messages, %d bytes\n", c.MessagesIn.Load(), c.BytesIn.Load()) +
+		fmt.Sprintf("Received: %d messages, %d bytes\n", c.MessagesOut.Load(), c.BytesOut.Load())
+	return s.sendTo(clientID, out)
+}
+
+// maxStatusRunes keeps /status short enough to sit next to a name.
+const maxStatusRunes = 40
+
+// setStatus handles "/status [text]": a short note shown after the
+// caller's name in /users, e.g. "alice (coding)". No text clears it. Escape
+// sequences and control characters are always removed, as the status is
+// shown to everyone.
+func (s *Server) setStatus(clientID int, args string) error {
+	status := truncateRunes(strings.TrimSpace(protocol.StripControl(args)), maxStatusRunes)
+
+	s.lockClients.Lock()
+	if c := s.idToClient[clientID]; c != nil {
+		c.Status, c.away = status, false
+	}
+	s.lockClients.Unlock()
+
+	if status == "" {
+		return s.sendTo(clientID, "Status cleared.\n")
+	}
+	return s.sendTo(clientID, "Status set to: "+status+"\n")
+}
+
+// setAway handles "/away [reason]": the caller's status becomes "away" or
+// "away: reason" until /back puts the previous one back. The client's
+// -idle-away option sends "/away idle" after a spell without input.
+func (s *Server) setAway(clientID int, args string) error {
+	status := "away"
+	if reason := strings.TrimSpace(protocol.StripControl(args)); reason != "" {
+		status = truncateRunes("away: "+reason, maxStatusRunes)
+	}
+
+	s.lockClients.Lock()
+	if c := s.idToClient[clientID]; c != nil {
+		if !c.away {
+			c.awayFrom = c.Status
+		}
+		c.Status, c.away = status, true
+	}
+	s.lockClients.Unlock()
+
+	return s.sendTo(clientID, "You are marked as "+status+".\n")
+}
+
+// back handles "/back": undo /away, restoring the status from before it.
+func (s *Server) back(clientID int, _ string) error {
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	wasAway := c != nil && c.away
+	if wasAway {
+		c.Status, c.away = c.awayFrom, false
+	}
+	s.lockClients.Unlock()
+
+	if !wasAway {
+		return s.sendTo(clientID, "You are not marked as away.\n")
+	}
+	return s.sendTo(clientID, "Welcome back.\n")
+}
+
+// setEcho handles "/echo [on|off]", choosing whether the caller's own chat
+// lines are sent back to them.
+func (s *Server) setEcho(clientID int, args string) error {
+	var on bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		on = true
+	case "off":
+	case "":
+		s.lockClients.RLock()
+		c := s.idToClient[clientID]
+		on = c != nil && c.Echo
+		s.lockClients.RUnlock()
+		state := "off"
+		if on {
+			state = "on"
+		}
+		return s.sendTo(clientID, "Echo is "+state+".\n")
+	default:
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("echo")+" [on|off]\n")
+	}
+
+	s.lockClients.Lock()
+	if c := s.idToClient[clientID]; c != nil {
+		c.Echo = on
+	}
+	s.lockClients.Unlock()
+	if on {
+		return s.sendTo(clientID, "Echo on: your messages will be shown back to you.\n")
+	}
+	return s.sendTo(clientID, "Echo off.\n")
+}
+
+// errQuit ends a client's session on request; clientRoutine treats it like
+// any other handler error and closes the client.
+var errQuit = errors.New("client quit")
+
+// quit says goodbye and ends the session. The farewell is written before
+// returning so closing the connection can't cut it off.
+func (s *Server) quit(clientID int, _ string) error {
+	_ = s.sendToWait(clientID, "Goodbye!\n")
+	return errQuit
+}
+
+// findClientLocked returns the first named client called name, or nil.
+// Caller must hold lockClients.
+func (s *Server) findClientLocked(name string) *Client {
+	for _, c := range s.clientList {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// findClientsLocked returns every named client called name, in join order.
+// Names aren't unique, so callers that need one client go through
+// resolveClientLocked. Caller must hold lockClients.
+func (s *Server) findClientsLocked(name string) []*Client {
+	var found []*Client
+	for _, c := range s.clientList {
+		if c.Name == name {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// resolveClientLocked picks the one named client target refers to: "#12"
+// means client 12, anything else is a name. If several clients share the
+// name it returns nil and a reply telling the caller how to pick one; with
+// no match both are empty. Caller must hold lockClients.
+func (s *Server) resolveClientLocked(target string) (*Client, string) {
+	if idStr, ok := strings.CutPrefix(target, "#"); ok {
+		n, err := strconv.Atoi(idStr)
+		if c := s.idToClient[n]; err == nil && c != nil && slices.Contains(s.clientList, c) {
+			return c, ""
+		}
+		return nil, ""
+	}
+	switch found := s.findClientsLocked(target); len(found) {
+	case 0:
+		return nil, ""
+	case 1:
+		return found[0], ""
+	default:
+		return nil, "There are " + strconv.Itoa(len(found)) + " users named " + target + "; " + s.cmdRef("id") + " " + target + " lists their IDs, then use #<id> instead of the name.\n"
+	}
+}
+
+// showIDs handles "/id <name>": it lists the IDs of everyone online with
+// that name, for commands that take #<id> in place of a name.
+func (s *Server) showIDs(clientID int, args string) error {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("id")+" <user>\n")
+	}
+	s.lockClients.RLock()
+	var ids []string
+	for _, c := range s.findClientsLocked(name) {
+		ids = append(ids, "#"+strconv.Itoa(c.ID))
+	}
+	s.lockClients.RUnlock()
+
+	if len(ids) == 0 {
+		return s.sendTo(clientID, "No user named "+name+" is online.\n")
+	}
+	return s.sendTo(clientID, name+": "+strings.Join(ids, ", ")+"\n")
+}
+
+// nameTakenLocked reports whether a connected client already uses name or
+// an account reserves it. Caller must hold lockClients.
+func (s *Server) nameTakenLocked(name string) bool {
+	return s.findClientLocked(name) != nil || s.accounts.registered(name)
+}
+
+// guestNameLocked picks Guest<ID> for a client that logged in without a
+// name, adding a suffix in the unlikely case someone already chose it.
+// Caller must hold lockClients.
+func (s *Server) guestNameLocked(clientID int) string {
+	base := fmt.Sprintf("Guest%d", clientID)
+	name := base
+	for n := 2; s.nameTakenLocked(name); n++ {
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	return name
+}
+
+// directMessage handles "/msg <user> <text>". With Config.Acks set the sender
+// also hears whether the write to the recipient went through. With
+// Config.OfflineQueue set, a message for a user who isn't online is held
+// until they next log in.
+func (s *Server) directMessage(clientID int, args string) error {
+	cfg := s.config()
+	target, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	text = strings.TrimSpace(text)
+	if target == "" || text == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("msg")+" <user> <message>\n")
+	}
+	text = truncateRunes(text, cfg.MaxMessageRunes)
+
+	s.lockClients.RLock()
+	name := ""
+	if c := s.idToClient[clientID]; c != nil {
+		name = c.Name
+	}
+	targetID, blocked := -1, false
+	c, ambiguous := s.resolveClientLocked(target)
+	if c != nil {
+		targetID, blocked = c.ID, c.blocksLocked(name, true)
+	}
+	s.lockClients.RUnlock()
+
+	if ambiguous != "" {
+		return s.sendTo(clientID, ambiguous)
+	}
+	if targetID < 0 && strings.HasPrefix(target, "#") {
+		return s.sendTo(clientID, "Not delivered: no user has ID "+target+".\n")
+	}
+
+	if targetID < 0 && cfg.OfflineQueue > 0 {
+		if refusal := s.queueOffline(target, name, text); refusal != "" {
+			return s.sendTo(clientID, "Not delivered: "+refusal+"\n")
+		}
+		return s.sendTo(clientID, target+" is offline; your message will be delivered when they log in.\n")
+	}
+	if targetID < 0 {
+		return s.sendTo(clientID, "Not delivered: "+target+" is not online.\n")
+	}
+	if blocked {
+		// look delivered, so the sender can't tell and try another route
+		if cfg.Acks {
+			return s.sendTo(clientID, "Delivered to "+target+".\n")
+		}
+		return nil
+	}
+	return s.deliverDirect(clientID, targetID, target, protocol.DMPrefix+name+": "+text+"\n")
+}
+
+// deliverDirect sends line, a direct message for target, to targetID. With
+// acks on, it waits up to Config.AckTimeout for the write and tells the
+// sender whether it went out, failed, or is still pending.
+func (s *Server) deliverDirect(clientID, targetID int, target, line string) error {
+	cfg := s.config()
+	if !cfg.Acks {
+		if err := s.sendTo(targetID, line); err != nil {
+			s.closeClient(targetID, err)
+		}
+		return nil
+	}
+	switch err := s.sendToWithin(targetID, line, cfg.AckTimeout); {
+	case err == nil:
+		return s.sendTo(clientID, "Delivered to "+target+".\n")
+	case errors.Is(err, errNotConfirmed):
+		return s.sendTo(clientID, "Not confirmed: "+target+" hasn't received it yet; it stays queued for them.\n")
+	default:
+		s.closeClient(targetID, err)
+		return s.sendTo(clientID, "Not delivered: "+target+" is unreachable.\n")
+	}
+}
+
+// whisper handles "/w <user> <text>": a direct message that can only reach
+// someone in the sender's own group, for side conversations in a busy
+// one. Names resolve among the group's members only, so a namesake
+// elsewhere on the server is never picked.
+func (s *Server) whisper(clientID int, args string) error {
+	cfg := s.config()
+	target, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	text = strings.TrimSpace(text)
+	if target == "" || text == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("w")+" <user> <message>\n")
+	}
+	text = truncateRunes(text, cfg.MaxMessageRunes)
+
+	s.lockClients.RLock()
+	grp, inGroup := s.clientToGroup[clientID]
+	name := ""
+	if c := s.idToClient[clientID]; c != nil {
+		name = c.Name
+	}
+	var matches []*Client
+	for _, id := range s.groupsToClient[grp] {
+		c := s.idToClient[id]
+		if c != nil && id != clientID && (c.Name == target || "#"+strconv.Itoa(id) == target) {
+			matches = append(matches, c)
+		}
+	}
+	blocked := len(matches) == 1 && matches[0].blocksLocked(name, true)
+	s.lockClients.RUnlock()
+
+	switch {
+	case !inGroup:
+		return s.sendTo(clientID, "You are not part of any group; use "+s.cmdRef("msg")+" instead.\n")
+	case len(matches) == 0:
+		return s.sendTo(clientID, "Not delivered: "+target+" is not in "+grp+".\n")
+	case len(matches) > 1:
+		return s.sendTo(clientID, "There are "+strconv.Itoa(len(matches))+" members of "+grp+" named "+target+"; "+s.cmdRef("id")+" "+target+" lists their IDs, then use #<id> instead of the name.\n")
+	}
+	targetID := matches[0].ID
+	if blocked {
+		if cfg.Acks {
+			return s.sendTo(clientID, "Delivered to "+target+".\n")
+		}
+		return nil
+	}
+	return s.deliverDirect(clientID, targetID, target, "["+grp+" whisper] "+name+": "+text+"\n")
+}
+
+// changeNick handles "/nick <name>": the caller takes a name nobody online
+// uses and no account reserves, and their group hears about it. Changes
+// closer together than Config.NickCooldown are refused, so renames can't be
+// used to flood a group with notices.
+func (s *Server) changeNick(clientID int, args string) error {
+	cfg := s.config()
+	name := truncateRunes(strings.TrimSpace(strings.ReplaceAll(args, "\n", " ")), cfg.MaxNameRunes)
+	if name == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("nick")+" <new_name>\n")
+	}
+	if problem := nameProblem(name, cfg.CommandPrefix); problem != "" {
+		return s.sendTo(clientID, problem+"\n")
+	}
+	now := time.Now()
+
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	if c == nil {
+		s.lockClients.Unlock()
+		return errClientGone
+	}
+	old := c.Name
+	var reply string
+	switch {
+	case name == old:
+		reply = "You are already " + name + ".\n"
+	case now.Sub(c.lastNick) < cfg.NickCooldown:
+		wait := (cfg.NickCooldown - now.Sub(c.lastNick)).Round(time.Second)
+		reply = fmt.Sprintf("You're changing names too often; try again in %s.\n", max(wait, time.Second))
+	case s.accounts.registered(name):
+		reply = name + " is a registered name; use " + s.cmdRef("login") + " " + name + " <password>.\n"
+	case s.findClientLocked(name) != nil:
+		reply = name + " is already taken.\n"
+	}
+	if reply != "" {
+		s.lockClients.Unlock()
+		return s.sendTo(clientID, reply)
+	}
+	c.Name, c.lastNick = name, now
+	if own := s.sessions[c.resumeToken]; own != nil {
+		own.name = name
+	}
+	group, inGroup := s.clientToGroup[clientID]
+	var recipients []int
+	if inGroup {
+		recipients = append(recipients, s.groupsToClient[group]...)
+	}
+	s.lockClients.Unlock()
+
+	fmt.Printf("%s is now known as %s\n", old, name)
+	s.broadcast(recipients, "["+group+"] "+old+" is now known as "+name+".\n", clientID)
+	return s.sendTo(clientID, "You are now known as "+name+".\n")
+}
+
+// typing relays "/typing [user]" to the named DM peer, or to the sender's
+// group when no user is given. Signals arriving faster than TypingInterval
+// are dropped so a client can't flood its peers.
+func (s *Server) typing(clientID int, args string) {
+	target := strings.TrimSpace(args)
+	now := time.Now()
+
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	if c == nil || now.Sub(c.lastTyping) < s.config().TypingInterval {
+		s.lockClients.Unlock()
+		return
+	}
+	c.lastTyping = now
+	var recipients []int
+	if target != "" {
+		if peer, _ := s.resolveClientLocked(target); peer != nil && !peer.blocksLocked(c.Name, true) {
+			recipients = []int{peer.ID}
+		}
+	} else if grp, ok := s.clientToGroup[clientID]; ok {
+		for _, id := range s.groupsToClient[grp] {
+			if m := s.idToClient[id]; m != nil && !m.blocksLocked(c.Name, false) {
+				recipients = append(recipients, id)
+			}
+		}
+	}
+	out := protocol.TypingPrefix + c.Name + " is typing...\n"
+	s.lockClients.Unlock()
+
+	s.broadcast(recipients, out, clientID)
+}
+
+// Broadcast relays a chat line from senderID to the sender's group, or to
+// every named client when the sender isn't in a group. Recipients who are
+// @mentioned get a copy marked with protocol.MentionPrefix. The sender only
+// gets a copy with echo on. Recipients whose connection fails are dropped.
+// The line is also passed to any linked servers. Lines typed by clients
+// reach here through the middleware chain (see Use), which strips escape
+// sequences.
+func (s *Server) Broadcast(senderID int, text string) {
+	text = truncateRunes(text, s.config().MaxMessageRunes)
+	mentions := mentionedNames(text)
+
+	s.lockClients.RLock()
+	name, echo := "", false
+	if c := s.idToClient[senderID]; c != nil {
+		name, echo = c.Name, c.Echo
+	}
+	group, inGroup := s.clientToGroup[senderID]
+	var recipients []int
+	if inGroup {
+		recipients = append([]int(nil), s.groupsToClient[group]...)
+	} else {
+		recipients = make([]int, 0, len(s.clientList))
+		for _, meta := range s.clientList {
+			recipients = append(recipients, meta.ID)
+		}
+	}
+	msgID := s.msgSeq.Add(1)
+	out := s.chatLabel(group, msgID) + name + ": " + indentContinuation(text) + "\n"
+	s.recordLocked(group, historyLine{id: msgID, from: name, text: text, line: out})
+	var plain, mentioned []int
+	for _, id := range recipients {
+		switch c := s.idToClient[id]; {
+		case id == senderID:
+			if echo {
+				plain = append(plain, id)
+			}
+		case c != nil && c.blocksLocked(name, false):
+			// blocked the sender; they aren't told
+		case c != nil && mentions[c.Name]:
+			mentioned = append(mentioned, id)
+		default:
+			plain = append(plain, id)
+		}
+	}
+	s.lockClients.RUnlock()
+
+	s.broadcast(plain, out, -1)
+	s.broadcast(mentioned, protocol.MentionPrefix+out, -1)
+	s.relayLocal(group, name, text)
+}
@@ -0,0 +1,734 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+func TestJoinGroupCreatesThenJoins(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+}
+
+func TestJoinGroupTwice(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/join go")
+	alice.expect("You are already a part of a group.\n")
+
+	alice.send("/groups")
+	alice.expectQuiet("go (")
+}
+
+func TestEmptyGroupIsRemoved(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/leave")
+	alice.expect("You have left the group rust\n")
+	bob.send("/groups")
+	bob.expect("Available Groups:\n")
+	bob.expectQuiet("rust")
+	bob.send("/join rust")
+	bob.expect("Created group rust\n")
+}
+
+func TestGroupCreationLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxGroups = 3
+	cfg.GroupCreateLimit = 2
+	cfg.GroupCreateWindow = time.Hour
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	// per-client rate limit: joining existing groups doesn't count
+	for _, g := range []string{"a", "b"} {
+		alice.send("/join " + g)
+		alice.expect("Created group " + g + "\n")
+		alice.send("/leave")
+		alice.expect("You have left the group " + g + "\n")
+	}
+	bob.send("/join x")
+	bob.expect("Created group x\n")
+	alice.send("/join c")
+	alice.expect("You are creating groups too quickly; try again in ")
+	alice.send("/join x")
+	alice.expect("Successfully joined group x\n")
+
+	// global cap counts groups that exist right now
+	carol.send("/join y")
+	carol.expect("Created group y\n")
+	dave := connect(t, s, "dave")
+	dave.send("/join z")
+	dave.expect("Created group z\n")
+	eve := connect(t, s, "eve")
+	eve.send("/join w")
+	eve.expect("The server already has the maximum of 3 groups; join an existing one.\n")
+	eve.send("/groups")
+	eve.expectQuiet("w (")
+	eve.send("/join y")
+	eve.expect("Successfully joined group y\n")
+}
+
+func TestLeaveWhenNotInGroup(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/leave")
+	alice.expect("You are not part of any group.\n")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/leave")
+	alice.expect("You have left the group rust\n")
+}
+
+func TestUsersList(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	connect(t, s, "carol")
+
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n2. bob\n3. carol\n")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	bob.send("/users")
+	bob.expect("Users connected to rust:\n1. alice\n2. bob\n")
+}
+
+func TestStatus(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/status \x1b[31mcoding\x1b[0m " + strings.Repeat("x", maxStatusRunes))
+	alice.expect("Status set to: coding " + strings.Repeat("x", maxStatusRunes-len("coding ")) + "\n")
+	alice.send("/status coding")
+	alice.expect("Status set to: coding\n")
+	bob.send("/users")
+	bob.expect("Connected Users:\n1. alice (coding)\n2. bob\n")
+
+	alice.send("/status")
+	alice.expect("Status cleared.\n")
+	bob.send("/users")
+	bob.expect("Connected Users:\n1. alice\n2. bob\n")
+}
+
+func TestAwayAndBack(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/back")
+	alice.expect("You are not marked as away.\n")
+	alice.send("/status coding")
+	alice.expect("Status set to: coding\n")
+	alice.send("/away idle")
+	alice.expect("You are marked as away: idle.\n")
+	alice.send("/away")
+	alice.expect("You are marked as away.\n")
+	bob.send("/users")
+	bob.expect("Connected Users:\n1. alice (away)\n2. bob\n")
+
+	alice.send("/back")
+	alice.expect("Welcome back.\n")
+	bob.send("/users")
+	bob.expect("Connected Users:\n1. alice (coding)\n2. bob\n")
+
+	// a /status while away replaces the away status for good
+	alice.send("/away")
+	alice.expect("You are marked as away.\n")
+	alice.send("/status reviewing")
+	alice.expect("Status set to: reviewing\n")
+	alice.send("/back")
+	alice.expect("You are not marked as away.\n")
+}
+
+func TestListingsAreSorted(t *testing.T) {
+	s := newTestServer(t)
+	zed := connect(t, s, "zed")
+	connect(t, s, "Bob")
+	amy := connect(t, s, "amy")
+
+	zed.send("/users")
+	zed.expect("Connected Users:\n1. amy\n2. Bob\n3. zed\n")
+
+	zed.send("/join zulu")
+	zed.expect("Created group zulu\n")
+	amy.send("/join alpha")
+	amy.expect("Created group alpha\n")
+	for i := 0; i < 3; i++ {
+		zed.send("/groups")
+		zed.expect("Available Groups:\nalpha (1 user/s)\nzulu (1 user/s)\n")
+	}
+}
+
+func TestBroadcastGroupAndGlobal(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	alice.send("hello crabs")
+	bob.expect("[rust] alice: hello crabs\n")
+	carol.expectQuiet("hello crabs")
+	alice.expectQuiet("hello crabs")
+
+	carol.send("hi everyone")
+	alice.expect("[Global] carol: hi everyone\n")
+	bob.expect("[Global] carol: hi everyone\n")
+}
+
+func TestBroadcastMentions(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+	dave := connect(t, s, "dave")
+
+	alice.send("@bob @carol: lunch?")
+	bob.expect(protocol.MentionPrefix + "[Global] alice: @bob @carol: lunch?\n")
+	carol.expect(protocol.MentionPrefix + "[Global] alice: @bob @carol: lunch?\n")
+	dave.expect("[Global] alice: @bob @carol: lunch?\n")
+	dave.expectQuiet(protocol.MentionPrefix)
+}
+
+func TestBroadcastStripsEscapes(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("\x1b[2J\x1b[Hhi \x1b[31mthere\x1b[0m")
+	bob.expect("[Global] alice: hi there\n")
+	alice.send("/msg bob \x1b]0;pwned\x07psst")
+	bob.expect("[DM] alice: psst\n")
+
+	// a line that was nothing but escapes isn't relayed at all
+	alice.send("\x1b[2J")
+	alice.send("after")
+	bob.expect("[Global] alice: after\n")
+	bob.expectQuiet("alice: \n")
+
+	cfg := DefaultConfig()
+	cfg.StripControl = false
+	s = newTestServerWith(t, cfg)
+	alice = connect(t, s, "alice")
+	bob = connect(t, s, "bob")
+	alice.send("\x1b[1mbold\x1b[0m")
+	bob.expect("[Global] alice: \x1b[1mbold\x1b[0m\n")
+}
+
+func TestEcho(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("quiet")
+	bob.expect("[Global] alice: quiet\n")
+	alice.expectQuiet("quiet")
+
+	alice.send("/echo on")
+	alice.expect("Echo on")
+	alice.send("hi @alice")
+	alice.expect("[Global] alice: hi @alice\n")
+	alice.expectQuiet(protocol.MentionPrefix)
+	bob.expect("[Global] alice: hi @alice\n")
+
+	alice.send("/echo")
+	alice.expect("Echo is on.\n")
+	alice.send("/echo off")
+	alice.expect("Echo off.\n")
+	alice.send("/echo maybe")
+	alice.expect("Usage: /echo [on|off]\n")
+
+	cfg := DefaultConfig()
+	cfg.Echo = true
+	s = newTestServerWith(t, cfg)
+	carol := connect(t, s, "carol")
+	carol.send("/join rust")
+	carol.expect("Created group rust\n")
+	carol.send("mine")
+	carol.expect("[rust] carol: mine\n")
+}
+
+func TestSeen(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/seen bob")
+	alice.expect("bob is online now (last active ")
+	alice.send("/seen carol")
+	alice.expect("I haven't seen carol.\n")
+	alice.send("/seen")
+	alice.expect("Usage: /seen <username>\n")
+
+	bob.send("/quit")
+	bob.expect("Goodbye!\n")
+	bob.expectClosed()
+	alice.send("/seen bob")
+	alice.expect("bob was last seen ")
+}
+
+func TestLastSeenIsBounded(t *testing.T) {
+	s := newTestServer(t)
+	base := time.Now()
+	s.lockClients.Lock()
+	for i := 0; i < lastSeenLimit; i++ {
+		s.rememberLocked(fmt.Sprint("user", i), base.Add(time.Duration(i)*time.Second))
+	}
+	s.rememberLocked("latecomer", base.Add(time.Hour))
+	_, oldestKept := s.lastSeen["user0"]
+	size := len(s.lastSeen)
+	s.lockClients.Unlock()
+
+	if size != lastSeenLimit || oldestKept {
+		t.Fatalf("size = %d, user0 kept = %v; want %d entries without user0", size, oldestKept, lastSeenLimit)
+	}
+}
+
+func TestCommandsAreCaseInsensitiveWithAliases(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/USERS")
+	alice.expect("Connected Users:\n1. alice\n")
+	alice.send("/u")
+	alice.expect("Connected Users:\n1. alice\n")
+	alice.send("/J rust")
+	alice.expect("Created group rust\n")
+	alice.send("/g")
+	alice.expect("Available Groups:\nrust (1 user/s)\n")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/dance")
+	alice.expect("Unknown command, try /help\n")
+	alice.send("/usersfoo")
+	alice.expect("Unknown command, try /help\n")
+	bob.expectQuiet("dance")
+
+	alice.send("/help")
+	alice.expect("/users - List all connected users (/u)\n")
+}
+
+func TestSlashEscapeAndExactMatch(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("//users is how you list people")
+	bob.expect("[Global] alice: /users is how you list people\n")
+	alice.expectQuiet("Connected Users")
+
+	alice.send("/usersaurus rex!")
+	alice.expect("Unknown command, try /help\n")
+	alice.expectQuiet("Connected Users")
+}
+
+func TestQuit(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	bob.send("/quit")
+	bob.expect("Goodbye!\n")
+	bob.expectClosed()
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+func TestPing(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/ping 1700000000123")
+	alice.expect(protocol.PongPrefix + "1700000000123\n")
+	alice.send("/ping")
+	alice.expect(protocol.PongPrefix + "\n")
+}
+
+func TestStats(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	bob.send("/join rust")
+	bob.expect("Created group rust\n")
+	alice.send("/join rust")
+	alice.expect("Successfully joined group rust\n")
+	bob.send("/quit")
+	bob.expectClosed()
+
+	alice.send("/stats")
+	alice.expect("Server stats:\nUptime: ")
+	alice.expect("\nConnections handled: 2\nConnected clients: 1\nGroups: 1\n")
+}
+
+func TestMyStats(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	// the username and this command: 6 + 9 bytes with their newlines
+	alice.send("/mystats")
+	alice.expect("Your session:\nConnected for: ")
+	alice.expect("\nSent: 2 messages, 15 bytes\nReceived: ")
+}
+
+func TestBroadcastDropsDeadRecipient(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	_ = bob.conn.Close()
+	alice.send("anyone there?")
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+func TestDirectMessage(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/msg bob psst, over here")
+	bob.expect("[DM] alice: psst, over here\n")
+	carol.expectQuiet("psst")
+	alice.expectQuiet("Delivered")
+
+	alice.send("/msg dave hello?")
+	alice.expect("Not delivered: dave is not online.\n")
+
+	alice.send("/msg bob")
+	alice.expect("Usage: /msg <user> <message>\n")
+}
+
+func TestWhisper(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	connect(t, s, "carol")
+
+	alice.send("/w bob hi")
+	alice.expect("You are not part of any group; use /msg instead.\n")
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/w carol hi")
+	alice.expect("Not delivered: carol is not in rust.\n")
+
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	alice.send("/w bob psst, over here")
+	bob.expect("[rust whisper] alice: psst, over here\n")
+	alice.send("/w #2 again")
+	bob.expect("[rust whisper] alice: again\n")
+	alice.send("/w bob")
+	alice.expect("Usage: /w <user> <message>\n")
+}
+
+func TestDirectMessageDuplicateNames(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob1 := connect(t, s, "bob")
+	bob2 := connect(t, s, "bob")
+
+	s.lockClients.RLock()
+	found := s.findClientsLocked("bob")
+	s.lockClients.RUnlock()
+	if len(found) != 2 {
+		t.Fatalf("found %d bobs", len(found))
+	}
+	first, second := "#"+strconv.Itoa(found[0].ID), "#"+strconv.Itoa(found[1].ID)
+
+	alice.send("/msg bob hi")
+	alice.expect("There are 2 users named bob; /id bob lists their IDs, then use #<id> instead of the name.\n")
+	alice.send("/id bob")
+	alice.expect("bob: " + first + ", " + second + "\n")
+	alice.send("/id dave")
+	alice.expect("No user named dave is online.\n")
+
+	alice.send("/msg " + second + " just you")
+	bob2.expect("[DM] alice: just you\n")
+	bob1.expectQuiet("just you")
+	alice.send("/msg #999 anyone?")
+	alice.expect("Not delivered: no user has ID #999.\n")
+}
+
+func TestOfflineQueue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OfflineQueue = 2
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	alice.send("/msg bob first")
+	alice.expect("bob is offline; your message will be delivered when they log in.\n")
+	alice.send("/msg bob second")
+	alice.expect("bob is offline")
+	alice.send("/msg bob third")
+	alice.expect("Not delivered: too many messages are already waiting for bob.\n")
+
+	bob := dial(t, s)
+	bob.expect("username: ")
+	bob.send("bob")
+	bob.expect("Welcome bob!")
+	bob.expect("[DM] alice: first (sent ")
+	bob.expect("[DM] alice: second (sent ")
+	bob.expectQuiet("third")
+
+	// delivered once only
+	bob.send("/quit")
+	bob.expect("Goodbye!\n")
+	bob = dial(t, s)
+	bob.expect("username: ")
+	bob.send("bob")
+	bob.expect("Welcome bob!")
+	bob.expectQuiet("[DM]")
+}
+
+func TestOfflineQueueExpires(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OfflineQueue = 5
+	cfg.OfflineTTL = time.Millisecond
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	alice.send("/msg bob stale")
+	alice.expect("bob is offline")
+	time.Sleep(10 * time.Millisecond)
+
+	bob := dial(t, s)
+	bob.expect("username: ")
+	bob.send("bob")
+	bob.expect("Welcome bob!")
+	bob.expectQuiet("stale")
+}
+
+func TestOfflineQueueTotalCap(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OfflineQueue = 2
+	cfg.OfflineTotal = 3
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	for _, to := range []string{"bob", "bob", "carol"} {
+		alice.send("/msg " + to + " hi")
+		alice.expect(to + " is offline")
+	}
+	alice.send("/msg dave hi")
+	alice.expect("Not delivered: the server is holding too many offline messages; try again later.\n")
+}
+
+func TestOfflineQueueSweepsExpired(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OfflineQueue = 5
+	cfg.OfflineTTL = 20 * time.Millisecond
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	for i := range 20 {
+		name := fmt.Sprintf("nobody%d", i)
+		alice.send("/msg " + name + " hi")
+		alice.expect(name + " is offline")
+	}
+	time.Sleep(2 * cfg.OfflineTTL)
+	alice.send("/msg bob hi")
+	alice.expect("bob is offline")
+
+	s.lockClients.RLock()
+	defer s.lockClients.RUnlock()
+	if len(s.offline) != 1 || len(s.offline["bob"]) != 1 {
+		t.Fatalf("expired queues kept: %d recipients", len(s.offline))
+	}
+}
+
+func TestDirectMessageAcks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Acks = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/msg bob ping")
+	bob.expect("[DM] alice: ping\n")
+	alice.expect("Delivered to bob.\n")
+
+	bob.breakWrites()
+	alice.send("/msg bob still there?")
+	alice.expect("Not delivered: bob is unreachable.\n")
+}
+
+// A recipient whose socket stops taking writes must not freeze the
+// sender: without a write timeout the wait for the ack is what gives up.
+func TestDirectMessageAckTimesOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Acks = true
+	cfg.WriteTimeout = 0
+	cfg.AckTimeout = 100 * time.Millisecond
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	// bob logs in by hand and then never reads again
+	bob, serverSide := net.Pipe()
+	defer bob.Close()
+	go s.HandleConn(serverSide)
+	buf := make([]byte, 4096)
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Write([]byte("bob\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.send("/msg bob are you there?")
+	alice.expect("Not confirmed: bob hasn't received it yet; it stays queued for them.\n")
+	alice.send("/w bob nope")
+	alice.expect("You are not part of any group")
+}
+
+func TestTypingIsDebounced(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	alice.send("/typing")
+	bob.expect(protocol.TypingPrefix + "alice is typing...\n")
+	alice.send("/typing")
+	bob.expectQuiet("typing")
+	carol.expectQuiet("typing")
+
+	carol.send("/typing alice")
+	alice.expect(protocol.TypingPrefix + "carol is typing...\n")
+}
+
+func TestNickCooldown(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	alice.send("/nick bob")
+	alice.expect("bob is already taken.\n")
+	alice.send("/nick ally")
+	alice.expect("You are now known as ally.\n")
+	bob.expect("[rust] alice is now known as ally.\n")
+
+	alice.send("/nick al")
+	alice.expect("You're changing names too often")
+	bob.expectQuiet("known as")
+	bob.send("/msg ally hi")
+	alice.expect("hi")
+}
+
+// Run with -race: readers (/users, /groups, chat) and writers (/join,
+// /leave) interleave across clients.
+func TestConcurrentCommands(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QueueSize = 1024 // bursts here are deliberate, not a slow client
+	s := newTestServerWith(t, cfg)
+	var clients []*testClient
+	for i := 0; i < 4; i++ {
+		clients = append(clients, connect(t, s, fmt.Sprintf("user%d", i)))
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *testClient) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c.conn.Write([]byte(fmt.Sprintf("/join g%d\n/users\n/groups\nhi\n/leave\n", j%2)))
+			}
+			c.conn.Write([]byte("/msg user0 done\n"))
+		}(c)
+	}
+	for range clients {
+		clients[0].expect(": done\n")
+	}
+	wg.Wait()
+}
+
+func TestVersionHandshake(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	alice.send("/version")
+	alice.expect("Server version: dev (protocol 1)\n")
+	alice.expectQuiet("Your client")
+
+	bob := dial(t, s)
+	bob.expect(protocol.UsernamePrompt)
+	bob.send(protocol.ClientHello + "0 v0.9")
+	bob.expect("Your client speaks protocol 0, but this server speaks 1")
+	bob.send("bob")
+	bob.expect("Welcome bob!")
+	bob.send("/version")
+	bob.expect("Server version: dev (protocol 1)\nYour client: v0.9 (protocol 0)\n")
+}
+
+func TestTrafficCounters(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("hi bob") // 7 bytes with the newline
+	bob.expect("[Global] alice: hi bob\n")
+	alice.send("/stats") // 7 more, after "alice\n"
+	alice.expect("Your traffic: 20 bytes in, ")
+
+	s.lockClients.RLock()
+	c := s.idToClient[2]
+	s.lockClients.RUnlock()
+	if got := c.BytesOut.Load(); got < int64(len("[Global] alice: hi bob\n")) {
+		t.Errorf("bob BytesOut = %d", got)
+	}
+	if got := s.bytesIn.Load(); got != 20+int64(len("bob\n")) {
+		t.Errorf("server bytesIn = %d", got)
+	}
+}
@@ -0,0 +1,40 @@
+package chat
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// how long a compressing server waits for the hello before carrying on
+// with a plain session
+const compressHandshakeTimeout = 200 * time.Millisecond
+
+// negotiateCompression waits briefly for the client's hello. If it comes,
+// the server answers and returns conn wrapped in deflate; otherwise any
+// byte it read is replayed and the session stays plain.
+func negotiateCompression(conn net.Conn) net.Conn {
+	_ = conn.SetReadDeadline(time.Now().Add(compressHandshakeTimeout))
+	var first [1]byte
+	n, _ := conn.Read(first[:])
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if n == 0 {
+		return conn
+	}
+	if string(first[:]) != protocol.CompressHello {
+		return protocol.Replay(conn, first[:])
+	}
+	if _, err := conn.Write([]byte(protocol.CompressHello)); err != nil {
+		return conn
+	}
+	return protocol.NewFlateConn(conn, conn)
+}
+
+// stripHello drops a hello from a client that asked for compression from
+// a server that isn't offering it; it would otherwise end up in the name.
+func stripHello(line string) string {
+	return strings.TrimPrefix(line, protocol.CompressHello)
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"net"
+	"testing"
+
+	"chat-app-go/protocol"
+)
+
+// listenLoopback serves s on a fresh loopback TCP port and returns its address.
+func listenLoopback(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+func TestCompressedSession(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Compress = true
+	s := newTestServerWith(t, cfg)
+
+	conn, err := net.Dial("tcp", listenLoopback(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(protocol.CompressHello)); err != nil {
+		t.Fatal(err)
+	}
+	var reply [1]byte
+	if _, err := conn.Read(reply[:]); err != nil || string(reply[:]) != protocol.CompressHello {
+		t.Fatalf("handshake reply = %q, %v", reply, err)
+	}
+	loginOver(t, protocol.NewFlateConn(conn, conn), "zip")
+}
+
+func TestCompressionNeedsBothSides(t *testing.T) {
+	// server offers, client doesn't ask
+	cfg := DefaultConfig()
+	cfg.Compress = true
+	s := newTestServerWith(t, cfg)
+	plain, err := net.Dial("tcp", listenLoopback(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	loginOver(t, plain, "plain")
+
+	// client asks, server doesn't offer: the hello must not leak into the name
+	s = newTestServer(t)
+	conn, err := net.Dial("tcp", listenLoopback(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(protocol.CompressHello)); err != nil {
+		t.Fatal(err)
+	}
+	loginOver(t, conn, "asker")
+}
@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	errClientGone   = errors.New("client missing")
+	errQueueFull    = errors.New("outbound queue full")
+	errNotConfirmed = errors.New("write not confirmed in time")
+)
+
+// outMsg is one queued write. If result is set, writeLoop reports the
+// outcome of the write on it.
+type outMsg struct {
+	text   string
+	result chan error
+}
+
+// writeLoop is the only goroutine that writes to c.Conn (apart from the
+// shutdown notice in Close). It drains c.out until the client is closed.
+// Each write gets a fresh deadline, so a stalled reader fails this write
+// without an earlier slow moment counting against a later one.
+func (s *Server) writeLoop(c *Client) {
+	defer s.conns.Done()
+	for {
+		select {
+		case m := <-c.out:
+			if timeout := s.config().WriteTimeout; timeout > 0 {
+				_ = c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			err := writeFull(c.Conn, []byte(m.text))
+			if err == nil {
+				c.BytesOut.Add(int64(len(m.text)))
+				c.MessagesOut.Add(1)
+				s.bytesOut.Add(int64(len(m.text)))
+			}
+			if m.result != nil {
+				m.result <- err
+			}
+			if err != nil {
+				s.closeClient(c.ID, fmt.Errorf("write failed: %w", err))
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeFull writes all of b, retrying after short writes. net.Conn
+// implementations report a short write as an error, but wrappers such as
+// the compression layer need not, and a half-sent line would corrupt the
+// stream for every message after it.
+func writeFull(w io.Writer, b []byte) error {
+	for len(b) > 0 {
+		n, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// enqueue hands m to the client's writer without blocking. A full queue
+// means the client can't keep up; callers drop it with closeClient.
+func enqueue(c *Client, m outMsg) error {
+	select {
+	case c.out <- m:
+		return nil
+	case <-c.done:
+		return errClientGone
+	default:
+		return errQueueFull
+	}
+}
+
+func (s *Server) lookupClient(clientID int) *Client {
+	s.lockClients.RLock()
+	defer s.lockClients.RUnlock()
+	return s.idToClient[clientID]
+}
+
+// sendTo queues msg for one client. A nil error means the message was
+// accepted for delivery, not that it has reached the socket yet.
+func (s *Server) sendTo(clientID int, msg string) error {
+	c := s.lookupClient(clientID)
+	if c == nil {
+		return errClientGone
+	}
+	return enqueue(c, outMsg{text: msg})
+}
+
+// countIn adds a line read from c, plus its newline, to the traffic counters.
+func (s *Server) countIn(c *Client, line string) {
+	n := int64(len(line) + 1)
+	c.BytesIn.Add(n)
+	c.MessagesIn.Add(1)
+	s.bytesIn.Add(n)
+}
+
+// broadcast queues msg for every client in recipients except exclude (pass
+// -1 to exclude nobody), dropping any that can't take it. No recipient can
+// hold up the others: each has its own writer goroutine and enqueue never
+// blocks, so a stalled socket only fills that client's queue. The clients
+// are looked up under one read lock and the ones whose queue was full are
+// closed once the loop is done, never in the middle of it. broadcast takes
+// lockClients itself, so the caller must not hold it: copy the IDs under
+// the lock, release it, then call broadcast.
+func (s *Server) broadcast(recipients []int, msg string, exclude int) {
+	s.lockClients.RLock()
+	clients := make([]*Client, 0, len(recipients))
+	for _, id := range recipients {
+		if c := s.idToClient[id]; c != nil && id != exclude {
+			clients = append(clients, c)
+		}
+	}
+	s.lockClients.RUnlock()
+
+	type failure struct {
+		id  int
+		err error
+	}
+	var failed []failure
+	for _, c := range clients {
+		if err := enqueue(c, outMsg{text: msg}); err != nil {
+			failed = append(failed, failure{c.ID, err})
+		}
+	}
+	for _, f := range failed {
+		s.closeClient(f.id, f.err)
+	}
+}
+
+// sendToWait queues msg and waits until it has actually been written,
+// returning the write error. Used where the caller must report delivery.
+func (s *Server) sendToWait(clientID int, msg string) error {
+	return s.sendToWithin(clientID, msg, 0)
+}
+
+// sendToWithin is sendToWait giving up after limit (0 waits as long as it
+// takes) with errNotConfirmed. The message stays queued and may still go
+// out, so a stalled recipient can't hold the caller's read loop forever.
+func (s *Server) sendToWithin(clientID int, msg string, limit time.Duration) error {
+	c := s.lookupClient(clientID)
+	if c == nil {
+		return errClientGone
+	}
+	result := make(chan error, 1)
+	if err := enqueue(c, outMsg{text: msg, result: result}); err != nil {
+		return err
+	}
+	var expired <-chan time.Time
+	if limit > 0 {
+		timer := time.NewTimer(limit)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-c.done:
+		return errClientGone
+	case <-expired:
+		return errNotConfirmed
+	}
+}
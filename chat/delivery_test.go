@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// shortWriter accepts at most max bytes per call without reporting an error.
+type shortWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	if len(b) > w.max {
+		b = b[:w.max]
+	}
+	return w.buf.Write(b)
+}
+
+func TestWriteFullRetriesShortWrites(t *testing.T) {
+	w := &shortWriter{max: 3}
+	msg := "[Global] alice: a message longer than one write\n"
+	if err := writeFull(w, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.buf.String(); got != msg {
+		t.Fatalf("wrote %q, want %q", got, msg)
+	}
+
+	if err := writeFull(&shortWriter{max: 0}, []byte("stuck")); err != io.ErrShortWrite {
+		t.Fatalf("no progress: err = %v, want io.ErrShortWrite", err)
+	}
+}
+
+func TestBroadcastHelper(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	// unknown IDs are skipped, the excluded one gets nothing
+	s.broadcast([]int{1, 2, 99}, "hello\n", 2)
+	alice.expect("hello\n")
+	bob.expectQuiet("hello")
+}
+
+func TestBroadcastNotHeldUpBySlowRecipient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 0
+	s := newTestServerWith(t, cfg)
+
+	// bob logs in first, so he is ahead of alice in every recipient list,
+	// and then stops reading
+	bob, serverSide := net.Pipe()
+	defer bob.Close()
+	go s.HandleConn(serverSide)
+	buf := make([]byte, 4096)
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Write([]byte("bob\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	alice := connect(t, s, "alice")
+	carol := connect(t, s, "carol")
+
+	for i := range 5 {
+		msg := fmt.Sprintf("line %d", i)
+		carol.send(msg)
+		alice.expect("[Global] carol: " + msg + "\n")
+	}
+}
@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// command is one entry in the slash-command table.
+type command struct {
+	name    string
+	aliases []string
+	usage   string // argument synopsis for /help, e.g. "<group_name>"
+	help    string
+	run     func(s *Server, clientID int, args string) error
+}
+
+var (
+	commandTable []command
+	commandIndex = make(map[string]*command) // lowercase name or alias -> entry
+)
+
+func init() {
+	commandTable = []command{
+		{name: "users", aliases: []string{"u"}, help: "List all connected users",
+			run: (*Server).getUsersList},
+		{name: "join", aliases: []string{"j"}, usage: "<group_name>", help: "Join a group",
+			run: (*Server).JoinGroup},
+		{name: "groups", aliases: []string{"g"}, help: "List all available groups",
+			run: func(s *Server, id int, _ string) error { return s.listGroups(id) }},
+		{name: "mygroups", help: "List the groups you are in",
+			run: (*Server).myGroups},
+		{name: "usergroups", usage: "<user>", help: "List the groups a user is in (admin only)",
+			run: (*Server).userGroups},
+		{name: "leave", aliases: []string{"l"}, help: "Leave the current group",
+			run: func(s *Server, id int, _ string) error { return s.leaveGroup(id) }},
+		{name: "rename", usage: "<new_group_name>", help: "Rename your group (owner only)",
+			run: (*Server).renameGroup},
+		{name: "setlimit", usage: "<n|default>", help: "Cap your group's size (owner only, 0 = no limit)",
+			run: (*Server).setGroupLimit},
+		{name: "topic", usage: "[text|-]", help: "Show your group's topic, or set it (owner only; - clears it)",
+			run: (*Server).setTopic},
+		{name: "export", usage: "<group>", help: "Save a group's recent history to a file on the server (admin only; owners too with -owner-export)",
+			run: (*Server).exportHistory},
+		{name: "search", usage: "<term>", help: "Find recent lines in your group's history",
+			run: (*Server).searchHistory},
+		{name: "msg", aliases: []string{"m"}, usage: "<user> <message>", help: "Send a private message",
+			run: (*Server).directMessage},
+		{name: "w", usage: "<user> <message>", help: "Send a private message to a member of your group",
+			run: (*Server).whisper},
+		{name: "nick", usage: "<new_name>", help: "Change your username",
+			run: (*Server).changeNick},
+		{name: "id", usage: "<user>", help: "List the IDs of users with a name, to use as #<id> in /msg",
+			run: (*Server).showIDs},
+		{name: "reply", usage: "<id> <message>", help: "Answer message #id, quoting it",
+			run: (*Server).reply},
+		{name: "gmsg", usage: "<group> <message>", help: "Post into a group without joining it (admins, or your own group)",
+			run: (*Server).groupMessage},
+		{name: "block", usage: "[user [dm]]", help: "Stop seeing a user's messages (dm: only their DMs); no user lists your blocks",
+			run: (*Server).blockUser},
+		{name: "unblock", usage: "<user>", help: "Undo /block",
+			run: (*Server).unblockUser},
+		{name: "typing", usage: "[user]", help: "Tell your group (or one user) you are typing",
+			run: func(s *Server, id int, args string) error { s.typing(id, args); return nil }},
+		{name: "seen", usage: "<user>", help: "Show when a user was last active",
+			run: (*Server).seen},
+		{name: "status", usage: "[text]", help: "Show a short status next to your name in /users; no text clears it",
+			run: (*Server).setStatus},
+		{name: "away", usage: "[reason]", help: "Mark yourself away in /users until /back",
+			run: (*Server).setAway},
+		{name: "back", help: "Undo /away, restoring your previous status",
+			run: (*Server).back},
+		{name: "echo", usage: "[on|off]", help: "Show your own messages back to you",
+			run: (*Server).setEcho},
+		{name: "ping", usage: "[token]", help: "Echo token back to measure round-trip time",
+			run: (*Server).ping},
+		{name: "mystats", help: "Show your own traffic and time connected",
+			run: (*Server).myStats},
+		{name: "version", help: "Show the server's version",
+			run: (*Server).version},
+		{name: "stats", help: "Show server uptime and usage counters",
+			run: func(s *Server, id int, _ string) error { return s.stats(id) }},
+		{name: "auth", usage: "<password>", help: "Log in as a server admin",
+			run: (*Server).auth},
+		{name: "announce", usage: "<text>", help: "Send an announcement to everyone (admin only)",
+			run: (*Server).announce},
+		{name: "create", usage: "<group> [keep]", help: "Create a group without joining it; keep makes it permanent (admin only)",
+			run: (*Server).createGroup},
+		{name: "list", help: "List every user with their group, then every group (admin only)",
+			run: (*Server).listAll},
+		{name: "disconnect", usage: "<id> [reason]", help: "Drop one connection by its ID (admin only)",
+			run: (*Server).disconnect},
+		{name: "register", usage: "<name> <password>", help: "Reserve a name with a password and log in as it",
+			run: (*Server).registerAccount},
+		{name: "login", usage: "<name> <password>", help: "Log in to a registered name",
+			run: (*Server).loginAccount},
+		{name: "resume", usage: "<token>", help: "Pick up a dropped session and rejoin its group",
+			run: (*Server).resume},
+		{name: "quit", aliases: []string{"exit"}, help: "Disconnect",
+			run: (*Server).quit},
+		{name: "help", aliases: []string{"h", "?"}, help: "Show this list",
+			run: func(s *Server, id int, _ string) error { return s.sendTo(id, helpText(s.config().CommandPrefix)) }},
+	}
+	for i := range commandTable {
+		cmd := &commandTable[i]
+		commandIndex[cmd.name] = cmd
+		for _, a := range cmd.aliases {
+			commandIndex[a] = cmd
+		}
+	}
+}
+
+// parseCommand splits a "/name args" line, where "/" is the configured
+// prefix. The name is lowercased so commands are case-insensitive; ok is
+// false for lines that aren't commands.
+func parseCommand(line, prefix string) (name, args string, ok bool) {
+	word, found := strings.CutPrefix(line, prefix)
+	if !found {
+		return "", "", false
+	}
+	rest := ""
+	if i := strings.IndexFunc(word, unicode.IsSpace); i >= 0 {
+		word, rest = word[:i], word[i:]
+	}
+	return strings.ToLower(word), strings.TrimSpace(rest), true
+}
+
+// runCommand dispatches a parsed command. Unknown names get a hint rather
+// than being relayed as chat.
+func (s *Server) runCommand(clientID int, name, args string) error {
+	cmd := commandIndex[name]
+	if cmd == nil {
+		return s.sendTo(clientID, "Unknown command, try "+s.cmdRef("help")+"\n")
+	}
+	return cmd.run(s, clientID, args)
+}
+
+// cmdRef spells a command the way users type it, e.g. "/help" or "!help"
+// with Config.CommandPrefix set to "!".
+func (s *Server) cmdRef(name string) string {
+	return s.config().CommandPrefix + name
+}
+
+// helpText lists every command, one per line, written with prefix.
+func helpText(prefix string) string {
+	var b strings.Builder
+	for _, cmd := range commandTable {
+		b.WriteString(prefix + cmd.name)
+		if cmd.usage != "" {
+			b.WriteString(" " + cmd.usage)
+		}
+		b.WriteString(" - " + cmd.help)
+		if len(cmd.aliases) > 0 {
+			b.WriteString(" (" + prefix + strings.Join(cmd.aliases, ", "+prefix) + ")")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line       string
+		name, args string
+		ok         bool
+	}{
+		{"/users", "users", "", true},
+		{"/JOIN  rust ", "join", "rust", true},
+		{"/msg bob hi there", "msg", "bob hi there", true},
+		{"/join\trust", "join", "rust", true},
+		{"/", "", "", true},
+		{"hello /users", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.line, "/")
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.line, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CommandPrefix = "!"
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("!help")
+	alice.expect("!users - List all connected users (!u)\n")
+	alice.send("!bogus")
+	alice.expect("Unknown command, try !help\n")
+	alice.send("!rename")
+	alice.expect("Usage: !rename <new_group_name>\n")
+
+	// the old prefix is plain chat now, and a doubled prefix escapes
+	alice.send("/users")
+	bob.expect("[Global] alice: /users\n")
+	alice.send("!!users")
+	bob.expect("[Global] alice: !users\n")
+}
+
+// stalledClient adds a logged-in admin who owns group and whose queue can
+// never take a message, so every send to it fails at once.
+func stalledClient(s *Server, name, group string) int {
+	id := s.newClientID()
+	s.lockClients.Lock()
+	defer s.lockClients.Unlock()
+	c := &Client{Name: name, ID: id, Addr: "test", IsAdmin: true,
+		out: make(chan outMsg), done: make(chan struct{})}
+	s.idToClient[id] = c
+	s.clientList = append(s.clientList, c)
+	if _, ok := s.groupsToClient[group]; !ok {
+		s.groupOwner[group] = id
+	}
+	s.groupsToClient[group] = append(s.groupsToClient[group], id)
+	s.clientToGroup[id] = group
+	return id
+}
+
+// Every handler replies through sendTo, which takes lockClients, and the
+// main loop closes the client when the reply fails. Any handler that sent
+// while still holding the lock, or a failure path that closed the client
+// under it, would deadlock here.
+func TestHandlersSurviveFailedWrites(t *testing.T) {
+	args := map[string]string{
+		"join": "go", "rename": "crab", "setlimit": "3", "topic": "borrowing",
+		"export": "rust", "search": "hi", "msg": "bob hi", "w": "bob hi",
+		"nick": "al", "id": "bob", "reply": "1 hi", "gmsg": "rust hi",
+		"block": "bob", "unblock": "bob", "seen": "bob", "echo": "on",
+		"ping": "tok", "auth": "pw", "announce": "hi", "disconnect": "1 bye",
+		"register": "al secret123", "login": "al secret123", "resume": "tok",
+		"users": "-v", "usergroups": "bob", "create": "go keep", "status": "coding",
+		"away": "lunch",
+	}
+	for _, cmd := range commandTable {
+		for _, arg := range []string{"", args[cmd.name]} {
+			cfg := DefaultConfig()
+			cfg.ExportDir = t.TempDir()
+			cfg.MessageIDs = true
+			// not newTestServerWith: its cleanup would hang on a lock
+			// a deadlocked handler never releases
+			s := New(cfg)
+			bob := connect(t, s, "bob")
+			bob.send("/join rust")
+			bob.expect("Created group rust\n")
+			id := stalledClient(s, "alice", "rust")
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if err := s.runCommand(id, cmd.name, arg); err != nil {
+					s.closeClient(id, err)
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(testTimeout):
+				t.Fatalf("/%s %s: handler or closeClient deadlocked after a failed write", cmd.name, arg)
+			}
+			s.Close()
+		}
+	}
+}
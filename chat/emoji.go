@@ -0,0 +1,110 @@
+package chat
+
+import "strings"
+
+// shortcodes maps the :name: codes Config.Emoji expands. It is kept small
+// on purpose: the common reactions, not a full emoji table.
+var shortcodes = map[string]string{
+	"smile":          "😄",
+	"grin":           "😁",
+	"joy":            "😂",
+	"wink":           "😉",
+	"blush":          "😊",
+	"heart_eyes":     "😍",
+	"thinking":       "🤔",
+	"neutral_face":   "😐",
+	"sweat_smile":    "😅",
+	"cry":            "😢",
+	"sob":            "😭",
+	"angry":          "😠",
+	"scream":         "😱",
+	"sunglasses":     "😎",
+	"sleeping":       "😴",
+	"roll_eyes":      "🙄",
+	"thumbsup":       "👍",
+	"+1":             "👍",
+	"thumbsdown":     "👎",
+	"-1":             "👎",
+	"clap":           "👏",
+	"wave":           "👋",
+	"pray":           "🙏",
+	"ok_hand":        "👌",
+	"muscle":         "💪",
+	"eyes":           "👀",
+	"heart":          "❤️",
+	"broken_heart":   "💔",
+	"fire":           "🔥",
+	"sparkles":       "✨",
+	"star":           "⭐",
+	"tada":           "🎉",
+	"rocket":         "🚀",
+	"100":            "💯",
+	"check":          "✅",
+	"x":              "❌",
+	"warning":        "⚠️",
+	"bug":            "🐛",
+	"coffee":         "☕",
+	"pizza":          "🍕",
+	"beer":           "🍺",
+	"point_up":       "☝️",
+	"raised_hands":   "🙌",
+	"shrug":          "🤷",
+	"facepalm":       "🤦",
+	"skull":          "💀",
+	"poop":           "💩",
+	"zap":            "⚡",
+	"question":       "❓",
+	"exclamation":    "❗",
+	"hourglass":      "⌛",
+	"lock":           "🔒",
+	"key":            "🔑",
+	"bulb":           "💡",
+	"memo":           "📝",
+	"link":           "🔗",
+	"calendar":       "📅",
+	"rainbow":        "🌈",
+	"sunny":          "☀️",
+	"umbrella":       "☔",
+	"snowflake":      "❄️",
+	"party":          "🥳",
+	"see_no_evil":    "🙈",
+	"upside_down":    "🙃",
+	"slightly_smile": "🙂",
+}
+
+// longest shortcode name worth looking up
+const maxShortcodeLen = 32
+
+// expandShortcodes replaces every known :name: in text with its emoji and
+// leaves everything else, unknown codes included, as it was. Codes are
+// ASCII and ':' never occurs inside a multi-byte UTF-8 sequence, so the
+// byte scan can't split a rune.
+func expandShortcodes(text string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(text, ':')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start+1:], ':')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		emoji, ok := "", end-start-1 <= maxShortcodeLen
+		if ok {
+			emoji, ok = shortcodes[text[start+1:end]]
+		}
+		if !ok {
+			// the closing colon may open the next code, as in "at 10:30 :wave:"
+			b.WriteString(text[:end])
+			text = text[end:]
+			continue
+		}
+		b.WriteString(text[:start])
+		b.WriteString(emoji)
+		text = text[end+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
@@ -0,0 +1,228 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// Server-to-server links reuse the client port. The dialing server answers
+// the username prompt with a hello line instead of a name; after that both
+// ends exchange relay frames, one per line:
+//
+//	\x1dPEER <node> <secret>           dialer -> acceptor
+//	\x1dPEER-OK <node>                 acceptor -> dialer
+//	\x1dMSG\t<origin>\t<seq>\t<group>\t<name>\t<text>
+//
+// group is empty for global chat. origin and seq identify a message across
+// the whole federation: each server relays a frame once, never back over
+// the link it arrived on, and drops frames it has already seen, so a message
+// can't loop even when the links form a cycle.
+const (
+	peerHello  = "\x1dPEER "
+	peerOK     = "\x1dPEER-OK "
+	relayFrame = "\x1dMSG\t"
+)
+
+var errPeerRefused = errors.New("peer link refused")
+
+// how many relayed message IDs are remembered for loop suppression
+const seenLimit = 4096
+
+// peer link reconnect backoff, same shape as the client's
+const (
+	peerInitialBackoff = time.Second
+	peerMaxBackoff     = 30 * time.Second
+)
+
+// ConnectPeer links this server to the one at addr, relaying global and
+// group chat both ways. It redials with backoff whenever the link drops and
+// returns only when the server is closed.
+func (s *Server) ConnectPeer(addr string) {
+	backoff := peerInitialBackoff
+	for {
+		err := s.dialPeer(addr, func() { backoff = peerInitialBackoff })
+		select {
+		case <-s.closing:
+			return
+		default:
+		}
+		fmt.Printf("peer %s: %v; retrying in %s\n", addr, err, backoff)
+		select {
+		case <-s.closing:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, peerMaxBackoff)
+	}
+}
+
+// dialPeer runs one link to addr until it fails. up is called once the
+// handshake succeeds.
+func (s *Server) dialPeer(addr string, up func()) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	c := s.register(conn)
+	if c == nil {
+		return net.ErrClosed
+	}
+	reader := bufio.NewReader(conn)
+
+	// the acceptor greets us like any client; the prompt has no newline
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := reader.ReadString(':'); err != nil {
+		s.closeClient(c.ID, err)
+		return err
+	}
+	cfg := s.config()
+	if err := s.sendTo(c.ID, peerHello+cfg.NodeName+" "+cfg.PeerSecret+"\n"); err != nil {
+		s.closeClient(c.ID, err)
+		return err
+	}
+	line, err := readLine(reader, maxLineBytes)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		s.closeClient(c.ID, err)
+		return err
+	}
+	node, ok := strings.CutPrefix(strings.TrimLeft(line, " "), peerOK)
+	if !ok {
+		err = fmt.Errorf("link refused: %q", line)
+		s.closeClient(c.ID, err)
+		return err
+	}
+	up()
+	return s.runPeer(c.ID, reader, node)
+}
+
+// acceptPeer finishes the handshake for a connection whose first line was
+// a peer hello.
+func (s *Server) acceptPeer(clientID int, reader *bufio.Reader, hello string) {
+	node, secret, _ := strings.Cut(strings.TrimPrefix(hello, peerHello), " ")
+	want := s.config().PeerSecret
+	if want == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(want)) != 1 || node == "" {
+		_ = s.sendToWait(clientID, "Peer link refused.\n")
+		s.closeClient(clientID, errPeerRefused)
+		return
+	}
+	if err := s.sendTo(clientID, peerOK+s.config().NodeName+"\n"); err != nil {
+		s.closeClient(clientID, err)
+		return
+	}
+	_ = s.runPeer(clientID, reader, node)
+}
+
+// runPeer registers an established link and relays frames from it until
+// it fails.
+func (s *Server) runPeer(clientID int, reader *bufio.Reader, node string) (err error) {
+	s.lockClients.Lock()
+	s.peers[clientID] = node
+	s.lockClients.Unlock()
+	fmt.Println("peer linked:", node)
+
+	defer func() {
+		s.lockClients.Lock()
+		delete(s.peers, clientID)
+		s.lockClients.Unlock()
+		s.closeClient(clientID, err)
+		fmt.Println("peer unlinked:", node)
+	}()
+
+	for {
+		var line string
+		if line, err = readMessage(reader, maxLineBytes); err != nil {
+			return err
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, relayFrame), "\t", 5)
+		if !strings.HasPrefix(line, relayFrame) || len(fields) != 5 {
+			continue // not a frame, e.g. the peer's shutdown notice
+		}
+		origin, seq, group, name, text := fields[0], fields[1], fields[2], fields[3], fields[4]
+		if !s.markSeen(origin + "\t" + seq) {
+			continue
+		}
+		s.deliverRelayed(origin, group, name, text)
+		s.relay(line, clientID)
+	}
+}
+
+// markSeen records a federation message ID, reporting false if it was
+// already there.
+func (s *Server) markSeen(id string) bool {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+	if s.relaySeen[id] {
+		return false
+	}
+	s.relaySeen[id] = true
+	s.relayOrder = append(s.relayOrder, id)
+	if len(s.relayOrder) > seenLimit {
+		delete(s.relaySeen, s.relayOrder[0])
+		s.relayOrder = s.relayOrder[1:]
+	}
+	return true
+}
+
+// relayLocal sends a chat line written on this server to every peer.
+func (s *Server) relayLocal(group, name, text string) {
+	s.lockClients.RLock()
+	linked := len(s.peers) > 0
+	s.lockClients.RUnlock()
+	if !linked {
+		return
+	}
+	origin := s.config().NodeName
+	seq := strconv.FormatInt(s.relaySeq.Add(1), 10)
+	s.markSeen(origin + "\t" + seq)
+	s.relay(relayFrame+strings.Join([]string{origin, seq, group, name, text}, "\t"), -1)
+}
+
+// relay forwards a frame to every peer link except the one it came from.
+func (s *Server) relay(frame string, fromID int) {
+	s.lockClients.RLock()
+	links := make([]int, 0, len(s.peers))
+	for id := range s.peers {
+		if id != fromID {
+			links = append(links, id)
+		}
+	}
+	s.lockClients.RUnlock()
+
+	s.broadcast(links, frameMessage(frame)+"\n", -1)
+}
+
+// deliverRelayed shows a message from another server to the local members
+// of the same group, or to everyone for global chat. The sender is shown
+// as name@origin.
+func (s *Server) deliverRelayed(origin, group, name, text string) {
+	cfg := s.config()
+	if cfg.StripControl {
+		name, group, text = protocol.StripControl(name), protocol.StripControl(group), protocol.StripControlLines(text)
+	}
+	text = truncateRunes(text, cfg.MaxMessageRunes)
+
+	var recipients []int
+	s.lockClients.RLock()
+	if group != "" {
+		recipients = append(recipients, s.groupsToClient[group]...)
+	} else {
+		for _, c := range s.clientList {
+			recipients = append(recipients, c.ID)
+		}
+	}
+	msgID, from := s.msgSeq.Add(1), name+"@"+origin
+	out := s.chatLabel(group, msgID) + from + ": " + indentContinuation(text) + "\n"
+	s.recordLocked(group, historyLine{id: msgID, from: from, text: text, line: out})
+	s.lockClients.RUnlock()
+
+	s.broadcast(recipients, out, -1)
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func newNode(t *testing.T, name string) (*Server, string) {
+	cfg := DefaultConfig()
+	cfg.NodeName = name
+	cfg.PeerSecret = "s3cret"
+	s := newTestServerWith(t, cfg)
+	return s, listenLoopback(t, s)
+}
+
+// waitLinks blocks until s has n peer links.
+func waitLinks(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lockClients.RLock()
+		got := len(s.peers)
+		s.lockClients.RUnlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s: peer links never reached %d", s.config().NodeName, n)
+}
+
+func TestFederationRelaysChat(t *testing.T) {
+	a, addrA := newNode(t, "a")
+	b, _ := newNode(t, "b")
+	go b.ConnectPeer(addrA)
+	waitLinks(t, a, 1)
+	waitLinks(t, b, 1)
+
+	alice := connectMultiline(t, a, "alice")
+	bob := connect(t, b, "bob")
+
+	alice.send("hello from a")
+	bob.expect("[Global] alice@a: hello from a\n")
+	bob.send("hi back")
+	alice.expect("[Global] bob@b: hi back\n")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Created group rust\n")
+	alice.send("group chat")
+	bob.expect("[rust] alice@a: group chat\n")
+	alice.send(`two\`)
+	alice.send("lines")
+	bob.expect("[rust] alice@a: two\n    lines\n")
+}
+
+func TestFederationNoLoops(t *testing.T) {
+	a, addrA := newNode(t, "a")
+	b, addrB := newNode(t, "b")
+	c, _ := newNode(t, "c")
+	// a triangle: every message can come back around
+	go b.ConnectPeer(addrA)
+	go c.ConnectPeer(addrA)
+	go c.ConnectPeer(addrB)
+	waitLinks(t, a, 2)
+	waitLinks(t, b, 2)
+	waitLinks(t, c, 2)
+
+	alice := connect(t, a, "alice")
+	bob := connect(t, b, "bob")
+	carol := connect(t, c, "carol")
+
+	alice.send("once")
+	bob.expect("alice@a: once\n")
+	carol.expect("alice@a: once\n")
+	alice.expectQuiet("once")
+	bob.expectQuiet("once")
+	carol.expectQuiet("once")
+}
+
+func TestFederationNeedsSecret(t *testing.T) {
+	a, addrA := newNode(t, "a")
+	cfg := DefaultConfig()
+	cfg.NodeName = "mallory"
+	cfg.PeerSecret = "guess"
+	m := newTestServerWith(t, cfg)
+
+	if err := m.dialPeer(addrA, func() {}); err == nil {
+		t.Fatal("link with the wrong secret was accepted")
+	}
+	waitLinks(t, a, 0)
+}
@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// how long a connection whose first read had no newline may take to send
+// the rest of its line before it is taken for a raw-chunk client
+const rawChunkWait = 300 * time.Millisecond
+
+// smallest read buffer a connection gets, whatever Config.ReadBuffer says
+const minReadBuffer = 64
+
+// detectFraming picks how to split a new connection's input into messages,
+// from its first read (the username, or a hello):
+//
+//   - a newline in the first read, or soon after it: the line protocol,
+//     with backslash continuation, which every current client speaks;
+//   - no newline within rawChunkWait: a legacy client started with
+//     -append-newline=false, which sends each message as one bare write.
+//     Each read from it is then treated as one message.
+//
+// The heuristic can't tell a raw-chunk client from a line client that
+// stalls mid-line for longer than rawChunkWait, such as telnet in
+// character mode with a slow typist; that client ends up in raw mode and
+// every read becomes its own message. Raw mode also can't separate two
+// messages that arrive in one read, or rejoin one split across reads.
+//
+// deadline is the login deadline (zero for none). The probe for more input
+// never waits past it, and it is back in force when detectFraming returns.
+func (s *Server) detectFraming(c *Client, deadline time.Time) (*bufio.Reader, error) {
+	_ = c.Conn.SetReadDeadline(deadline)
+	size := max(s.config().ReadBuffer, minReadBuffer)
+	buf := make([]byte, size)
+	n, err := c.Conn.Read(buf)
+	if n == 0 {
+		if err == nil {
+			err = io.ErrNoProgress
+		}
+		return nil, err
+	}
+	first := buf[:n]
+	if err == nil && bytes.IndexByte(first, '\n') < 0 {
+		probe := time.Now().Add(rawChunkWait)
+		if !deadline.IsZero() && deadline.Before(probe) {
+			probe = deadline
+		}
+		_ = c.Conn.SetReadDeadline(probe)
+		more := make([]byte, size)
+		m, err := c.Conn.Read(more)
+		_ = c.Conn.SetReadDeadline(deadline)
+		var ne net.Error
+		if m == 0 && errors.As(err, &ne) && ne.Timeout() {
+			fmt.Printf("client at %s sends bare chunks; reading it in raw mode\n", c.Addr)
+			return bufio.NewReaderSize(io.MultiReader(bytes.NewReader(append(first, '\n')), chunkReader{c.Conn}), size), nil
+		}
+		first = append(first, more[:m]...)
+	}
+	return bufio.NewReaderSize(io.MultiReader(bytes.NewReader(first), c.Conn), size), nil
+}
+
+// chunkReader ends every read that doesn't already end a line with a
+// newline, so the line reader sees each raw-chunk message as one line.
+type chunkReader struct {
+	r io.Reader
+}
+
+func (cr chunkReader) Read(b []byte) (int, error) {
+	if len(b) < 2 {
+		return cr.r.Read(b)
+	}
+	n, err := cr.r.Read(b[:len(b)-1])
+	if n > 0 && b[n-1] != '\n' {
+		b[n] = '\n'
+		n++
+	}
+	return n, err
+}
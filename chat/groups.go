@@ -0,0 +1,454 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app-go/protocol"
+)
+
+// maxGroupNameRunes caps group names so /groups stays readable.
+const maxGroupNameRunes = 32
+
+// groupNameProblem explains why name can't be used for a group, or returns
+// "" if it can. Names are a single word: no whitespace or control characters.
+func groupNameProblem(name string) string {
+	switch {
+	case name == "":
+		return "Please specify a group name.\n"
+	case utf8.RuneCountInString(name) > maxGroupNameRunes:
+		return fmt.Sprintf("Group names can be at most %d characters.\n", maxGroupNameRunes)
+	case strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0:
+		return "Group names cannot contain spaces or control characters.\n"
+	case reservedGroupName(name):
+		return name + " is a reserved name; please pick another.\n"
+	}
+	return ""
+}
+
+// reservedGroupName reports whether name, in any case, is global chat's
+// label or one of the server's line markers. Chat lines start with
+// "[group] ", so a group named "kicked" or "ANNOUNCEMENT" would let its
+// members send lines that clients take for server notices.
+func reservedGroupName(name string) bool {
+	if strings.EqualFold(name, "Global") {
+		return true
+	}
+	for _, marker := range protocol.Markers {
+		if strings.EqualFold("["+name+"] ", marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinGroup puts the client into groupName, creating the group if needed,
+// and tells the client the outcome. Joining an existing group also sends
+// its topic and, once the join is done and the lock released, the member
+// list /users would show. A non-nil error means the reply could not be
+// delivered.
+func (s *Server) JoinGroup(clientID int, groupName string) error {
+	groupName = strings.TrimSpace(groupName)
+	if problem := groupNameProblem(groupName); problem != "" {
+		return s.sendTo(clientID, problem)
+	}
+
+	s.lockClients.Lock()
+	msg := ""
+	joined := false // into a group that already existed
+	if _, inGroup := s.clientToGroup[clientID]; inGroup {
+		msg = "You are already a part of a group."
+	} else {
+		members, exists := s.groupsToClient[groupName]
+		if limit := s.groupLimitLocked(groupName); exists && limit > 0 && len(members) >= limit {
+			s.lockClients.Unlock()
+			return s.sendTo(clientID, "Group is full\n")
+		}
+		if !exists {
+			if s.config().StrictGroups {
+				s.lockClients.Unlock()
+				return s.sendTo(clientID, "Unknown group, ask an admin to create it.\n")
+			}
+			if refusal := s.creationRefusalLocked(clientID); refusal != "" {
+				s.lockClients.Unlock()
+				return s.sendTo(clientID, refusal)
+			}
+			s.groupsToClient[groupName] = []int{}
+			s.groupOwner[groupName] = clientID
+			msg = "Created group " + groupName
+		} else {
+			if _, owned := s.groupOwner[groupName]; !owned {
+				s.groupOwner[groupName] = clientID // first into a preloaded group
+			}
+			msg = "Successfully joined group " + groupName
+			joined = true
+			if topic := s.groupTopic[groupName]; topic != "" {
+				msg += "\nTopic: " + topic
+			}
+		}
+		s.groupsToClient[groupName] = append(s.groupsToClient[groupName], clientID)
+		s.clientToGroup[clientID] = groupName
+	}
+	s.lockClients.Unlock()
+
+	if err := s.sendTo(clientID, msg+"\n"); err != nil || !joined {
+		return err
+	}
+	return s.getUsersList(clientID, "")
+}
+
+// listGroups sends every group with its member count, alphabetically.
+func (s *Server) listGroups(clientID int) error {
+	s.lockClients.RLock()
+	groups := make([]string, 0, len(s.groupsToClient))
+	for grp := range s.groupsToClient {
+		groups = append(groups, grp)
+	}
+	sort.Strings(groups)
+	groupsList := "Available Groups:"
+	for _, grp := range groups {
+		groupsList += "\n" + grp + " (" + fmt.Sprintf("%d", len(s.groupsToClient[grp])) + " user/s)"
+	}
+	groupsList += "\n"
+	s.lockClients.RUnlock()
+
+	return s.sendTo(clientID, groupsList)
+}
+
+// groupsOfLocked returns the groups clientID belongs to, sorted. A client
+// is in at most one group today, but callers treat it as a list.
+// Caller must hold lockClients.
+func (s *Server) groupsOfLocked(clientID int) []string {
+	var groups []string
+	if grp, ok := s.clientToGroup[clientID]; ok {
+		groups = append(groups, grp)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// myGroups handles "/mygroups": the caller's group memberships.
+func (s *Server) myGroups(clientID int, _ string) error {
+	s.lockClients.RLock()
+	groups := s.groupsOfLocked(clientID)
+	s.lockClients.RUnlock()
+
+	if len(groups) == 0 {
+		return s.sendTo(clientID, "You are not part of any group.\n")
+	}
+	return s.sendTo(clientID, "Your groups: "+strings.Join(groups, ", ")+"\n")
+}
+
+// userGroups handles the admin command "/usergroups <user>": another
+// user's group memberships. #<id> picks one of several namesakes.
+func (s *Server) userGroups(clientID int, args string) error {
+	if !s.isAdmin(clientID) {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+	target := strings.TrimSpace(args)
+	if target == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("usergroups")+" <user>\n")
+	}
+
+	s.lockClients.RLock()
+	c, reply := s.resolveClientLocked(target)
+	var groups []string
+	name := target
+	if c != nil {
+		groups, name = s.groupsOfLocked(c.ID), c.Name
+	}
+	s.lockClients.RUnlock()
+
+	switch {
+	case reply != "":
+		return s.sendTo(clientID, reply)
+	case c == nil:
+		return s.sendTo(clientID, "No user named "+target+" is online.\n")
+	case len(groups) == 0:
+		return s.sendTo(clientID, name+" is not part of any group.\n")
+	}
+	return s.sendTo(clientID, name+"'s groups: "+strings.Join(groups, ", ")+"\n")
+}
+
+func (s *Server) leaveGroup(clientID int) error {
+	s.lockClients.Lock()
+	grp, ok := s.removeFromGroupLocked(clientID)
+	s.lockClients.Unlock()
+
+	if !ok {
+		return s.sendTo(clientID, "You are not part of any group.\n")
+	}
+	return s.sendTo(clientID, "You have left the group "+grp+"\n")
+}
+
+// removeFromGroupLocked takes the client out of its group, if any, and
+// returns the group it left. If the client owned the group, ownership passes
+// to the longest-standing remaining member. Caller must hold lockClients.
+func (s *Server) removeFromGroupLocked(clientID int) (string, bool) {
+	grp, ok := s.clientToGroup[clientID]
+	if !ok {
+		return "", false
+	}
+	s.groupsToClient[grp] = removeIntFromSlice(s.groupsToClient[grp], clientID)
+	delete(s.clientToGroup, clientID)
+
+	// the last one out removes the group, freeing its slot under MaxGroups;
+	// groups from Config.Groups stay, but the next joiner becomes the owner
+	if members := s.groupsToClient[grp]; len(members) == 0 {
+		if !s.fixedGroups[grp] {
+			delete(s.groupsToClient, grp)
+			delete(s.groupTopic, grp)
+			s.moveHistoryLocked(grp, "")
+		}
+		delete(s.groupOwner, grp)
+		delete(s.groupLimit, grp)
+	} else if s.groupOwner[grp] == clientID {
+		s.groupOwner[grp] = members[0]
+	}
+	return grp, true
+}
+
+// renameGroup handles "/rename <newname>": the owner of the caller's group
+// moves it, with every member, to a new name. The whole move happens under
+// one write lock so nobody can observe a half-renamed group.
+func (s *Server) renameGroup(clientID int, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("rename")+" <new_group_name>\n")
+	}
+	if problem := groupNameProblem(newName); problem != "" {
+		return s.sendTo(clientID, problem)
+	}
+
+	s.lockClients.Lock()
+	oldName, inGroup := s.clientToGroup[clientID]
+	var reply string
+	var members []int
+	switch {
+	case !inGroup:
+		reply = "You are not part of any group.\n"
+	case s.groupOwner[oldName] != clientID:
+		reply = "Only the owner of " + oldName + " can rename it.\n"
+	case s.fixedGroups[oldName] || s.config().StrictGroups:
+		reply = "Groups on this server can't be renamed.\n"
+	case newName == oldName:
+		reply = "The group is already called " + newName + ".\n"
+	default:
+		if _, taken := s.groupsToClient[newName]; taken {
+			reply = "A group named " + newName + " already exists.\n"
+			break
+		}
+		s.renameGroupLocked(oldName, newName)
+		members = append([]int(nil), s.groupsToClient[newName]...)
+	}
+	name := ""
+	if c := s.idToClient[clientID]; c != nil {
+		name = c.Name
+	}
+	s.lockClients.Unlock()
+
+	if reply != "" {
+		return s.sendTo(clientID, reply)
+	}
+	out := "Group " + oldName + " was renamed to " + newName + " by " + name + "\n"
+	s.broadcast(members, out, clientID)
+	return s.sendTo(clientID, out)
+}
+
+// renameGroupLocked moves every piece of per-group state from oldName to
+// newName. Any new per-group map must be migrated here too.
+// Caller must hold the write lock.
+func (s *Server) renameGroupLocked(oldName, newName string) {
+	members := s.groupsToClient[oldName]
+	delete(s.groupsToClient, oldName)
+	s.groupsToClient[newName] = members
+	for _, id := range members {
+		s.clientToGroup[id] = newName
+	}
+
+	if owner, ok := s.groupOwner[oldName]; ok {
+		delete(s.groupOwner, oldName)
+		s.groupOwner[newName] = owner
+	}
+	if limit, ok := s.groupLimit[oldName]; ok {
+		delete(s.groupLimit, oldName)
+		s.groupLimit[newName] = limit
+	}
+	if topic, ok := s.groupTopic[oldName]; ok {
+		delete(s.groupTopic, oldName)
+		s.groupTopic[newName] = topic
+	}
+	s.moveHistoryLocked(oldName, newName)
+}
+
+// creationRefusalLocked explains why clientID may not create a group right
+// now, or returns "" if it may, recording the creation against its rate
+// limit. Caller must hold lockClients for writing.
+func (s *Server) creationRefusalLocked(clientID int) string {
+	cfg := s.config()
+	if cfg.MaxGroups > 0 && len(s.groupsToClient) >= cfg.MaxGroups {
+		return fmt.Sprintf("The server already has the maximum of %d groups; join an existing one.\n", cfg.MaxGroups)
+	}
+	c := s.idToClient[clientID]
+	if c == nil || cfg.GroupCreateLimit <= 0 {
+		return ""
+	}
+	now := time.Now()
+	recent := c.groupCreates[:0]
+	for _, t := range c.groupCreates {
+		if now.Sub(t) < cfg.GroupCreateWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.groupCreates = recent
+	if len(recent) >= cfg.GroupCreateLimit {
+		wait := cfg.GroupCreateWindow - now.Sub(recent[0])
+		return "You are creating groups too quickly; try again in " + wait.Round(time.Second).String() + ".\n"
+	}
+	c.groupCreates = append(c.groupCreates, now)
+	return ""
+}
+
+// groupLimitLocked returns the member cap for a group: its /setlimit
+// override if any, else the server default. 0 means unlimited.
+// Caller must hold lockClients.
+func (s *Server) groupLimitLocked(groupName string) int {
+	if limit, ok := s.groupLimit[groupName]; ok {
+		return limit
+	}
+	return s.config().MaxGroupSize
+}
+
+// setGroupLimit handles "/setlimit <n>": the owner overrides the member cap
+// of their group. 0 lifts the cap; "/setlimit default" restores the server
+// default. Members already in the group are never removed.
+func (s *Server) setGroupLimit(clientID int, args string) error {
+	args = strings.TrimSpace(args)
+	limit, err := strconv.Atoi(args)
+	if args != "default" && (err != nil || limit < 0) {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("setlimit")+" <n|default> (0 means no limit)\n")
+	}
+
+	s.lockClients.Lock()
+	grp, inGroup := s.clientToGroup[clientID]
+	var reply string
+	switch {
+	case !inGroup:
+		reply = "You are not part of any group.\n"
+	case s.groupOwner[grp] != clientID:
+		reply = "Only the owner of " + grp + " can change its limit.\n"
+	case args == "default":
+		delete(s.groupLimit, grp)
+		reply = "Member limit for " + grp + " reset to the server default.\n"
+	case limit == 0:
+		s.groupLimit[grp] = 0
+		reply = "Member limit for " + grp + " removed.\n"
+	default:
+		s.groupLimit[grp] = limit
+		reply = fmt.Sprintf("Member limit for %s set to %d.\n", grp, limit)
+	}
+	s.lockClients.Unlock()
+
+	return s.sendTo(clientID, reply)
+}
+
+// maxTopicRunes caps /topic so join confirmations stay short.
+const maxTopicRunes = 200
+
+// setTopic handles "/topic [text]": with text, the owner of the caller's
+// group sets its description and the members are told; "-" clears it.
+// Without text, anyone in the group gets the current topic back. Joiners
+// get it with their join confirmation, and it goes away with the group.
+func (s *Server) setTopic(clientID int, args string) error {
+	cfg := s.config()
+	text := args
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	text = truncateRunes(strings.TrimSpace(text), maxTopicRunes)
+
+	s.lockClients.Lock()
+	grp, inGroup := s.clientToGroup[clientID]
+	var reply, notice string
+	var members []int
+	switch {
+	case !inGroup:
+		reply = "You are not part of any group.\n"
+	case text == "" && s.groupTopic[grp] == "":
+		reply = "No topic is set for " + grp + ".\n"
+	case text == "":
+		reply = "Topic for " + grp + ": " + s.groupTopic[grp] + "\n"
+	case s.groupOwner[grp] != clientID:
+		reply = "Only the owner of " + grp + " can set its topic.\n"
+	default:
+		name := s.idToClient[clientID].Name
+		if text == "-" {
+			delete(s.groupTopic, grp)
+			notice = "[" + grp + "] " + name + " cleared the topic.\n"
+		} else {
+			s.groupTopic[grp] = text
+			notice = "[" + grp + "] " + name + " set the topic: " + text + "\n"
+		}
+		members = append(members, s.groupsToClient[grp]...)
+	}
+	s.lockClients.Unlock()
+
+	if reply != "" {
+		return s.sendTo(clientID, reply)
+	}
+	s.broadcast(members, notice, -1)
+	return nil
+}
+
+// groupMessage handles "/gmsg <group> <text>": it posts text into a named
+// group without joining it, marked as a cross-post so members can tell it
+// apart from their own group's chat. Admins may post anywhere; other users
+// only into the group they are in. The sender always sees one copy.
+func (s *Server) groupMessage(clientID int, args string) error {
+	cfg := s.config()
+	group, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	text = strings.TrimSpace(text)
+	if group == "" || text == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("gmsg")+" <group> <message>\n")
+	}
+	text = truncateRunes(text, cfg.MaxMessageRunes)
+
+	s.lockClients.RLock()
+	members, exists := s.groupsToClient[group]
+	c := s.idToClient[clientID]
+	var name string
+	var allowed bool
+	if c != nil {
+		name, allowed = c.Name, c.IsAdmin || s.clientToGroup[clientID] == group
+	}
+	var recipients []int
+	for _, id := range members {
+		if m := s.idToClient[id]; m != nil && !m.blocksLocked(name, false) {
+			recipients = append(recipients, id)
+		}
+	}
+	var out string
+	if exists && allowed {
+		msgID := s.msgSeq.Add(1)
+		out = s.chatLabel(group, msgID) + protocol.CrossPost + name + ": " + text + "\n"
+		s.recordLocked(group, historyLine{id: msgID, from: name, text: text, line: out})
+	}
+	s.lockClients.RUnlock()
+
+	if !exists {
+		return s.sendTo(clientID, "No such group\n")
+	}
+	if !allowed {
+		return s.sendTo(clientID, "Not authorized.\n")
+	}
+	s.broadcast(recipients, out, clientID)
+	return s.sendTo(clientID, out)
+}
@@ -0,0 +1,234 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenameGroup(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	carol.send("/join go")
+	carol.expect("Created group go\n")
+
+	bob.send("/rename crabs")
+	bob.expect("Only the owner of rust can rename it.\n")
+	alice.send("/rename go")
+	alice.expect("A group named go already exists.\n")
+	alice.send("/rename")
+	alice.expect("Usage: /rename <new_group_name>\n")
+
+	alice.send("/rename crabs")
+	alice.expect("Group rust was renamed to crabs by alice\n")
+	bob.expect("Group rust was renamed to crabs by alice\n")
+	carol.expectQuiet("renamed")
+
+	bob.send("/users")
+	bob.expect("Users connected to crabs:\n1. alice\n2. bob\n")
+	alice.send("/groups")
+	alice.expect("Available Groups:\ncrabs (2 user/s)\ngo (1 user/s)\n")
+}
+
+func TestOwnershipPassesOnLeave(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	alice.send("/leave")
+	alice.expect("You have left the group rust\n")
+	bob.send("/rename crabs")
+	bob.expect("Group rust was renamed to crabs by bob\n")
+
+	alice.send("/join crabs")
+	alice.expect("Successfully joined group crabs\n")
+	alice.send("/rename rust")
+	alice.expect("Only the owner of crabs can rename it.\n")
+}
+
+func TestGroupSizeLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxGroupSize = 2
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	carol.send("/join rust")
+	carol.expect("Group is full\n")
+
+	bob.send("/setlimit 3")
+	bob.expect("Only the owner of rust can change its limit.\n")
+	alice.send("/setlimit lots")
+	alice.expect("Usage: /setlimit <n|default> (0 means no limit)\n")
+	alice.send("/setlimit 3")
+	alice.expect("Member limit for rust set to 3.\n")
+	carol.send("/join rust")
+	carol.expect("Successfully joined group rust\n")
+
+	alice.send("/setlimit default")
+	alice.expect("Member limit for rust reset to the server default.\n")
+	alice.send("/rename crabs")
+	alice.expect("renamed to crabs")
+	carol.send("/leave")
+	carol.expect("You have left the group crabs\n")
+	carol.send("/join crabs")
+	carol.expect("Group is full\n")
+}
+
+func TestJoinValidatesGroupName(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("/join")
+	alice.expect("Please specify a group name.\n")
+	alice.send("/join   ")
+	alice.expect("Please specify a group name.\n")
+	alice.send("/join " + strings.Repeat("g", maxGroupNameRunes+1))
+	alice.expect("Group names can be at most 32 characters.\n")
+	alice.send("/join two words")
+	alice.expect("Group names cannot contain spaces or control characters.\n")
+	for _, name := range []string{"kicked", "ANNOUNCEMENT", "announcement", "DM", "typing", "pong", "mention", "Roster", "global"} {
+		alice.send("/join " + name)
+		alice.expect(name + " is a reserved name; please pick another.\n")
+	}
+	alice.send("/groups")
+	alice.expect("Available Groups:\n")
+
+	alice.send("/join   rust  ")
+	alice.expect("Created group rust\n")
+	alice.send("/rename bad\x07name")
+	alice.expect("Group names cannot contain spaces or control characters.\n")
+	alice.send("/rename Kicked")
+	alice.expect("Kicked is a reserved name; please pick another.\n")
+	alice.send("/rename ANNOUNCEMENT")
+	alice.expect("ANNOUNCEMENT is a reserved name; please pick another.\n")
+}
+
+func TestStrictGroups(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StrictGroups = true
+	cfg.Groups = []string{"general", "random"}
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/groups")
+	alice.expect("Available Groups:\ngeneral (0 user/s)\nrandom (0 user/s)\n")
+	alice.send("/join rust")
+	alice.expect("Unknown group, ask an admin to create it.\n")
+
+	alice.send("/join general")
+	alice.expect("Successfully joined group general\n")
+	alice.send("/rename chat")
+	alice.expect("Groups on this server can't be renamed.\n")
+	alice.send("/leave")
+	alice.expect("You have left the group general\n")
+
+	// the emptied group stays, and the next joiner owns it
+	bob.send("/join general")
+	bob.expect("Successfully joined group general\n")
+	bob.send("/setlimit 5")
+	bob.expect("Member limit for general set to 5.\n")
+	alice.send("/groups")
+	alice.expect("Available Groups:\ngeneral (1 user/s)\nrandom (0 user/s)\n")
+}
+
+func TestTopic(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/topic")
+	alice.expect("You are not part of any group.\n")
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/topic")
+	alice.expect("No topic is set for rust.\n")
+	alice.send("/topic Ownership and borrowing")
+	alice.expect("[rust] alice set the topic: Ownership and borrowing\n")
+
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\nTopic: Ownership and borrowing\n")
+	bob.send("/topic lifetimes")
+	bob.expect("Only the owner of rust can set its topic.\n")
+	bob.send("/topic")
+	bob.expect("Topic for rust: Ownership and borrowing\n")
+
+	alice.send("/rename crab")
+	bob.expect("was renamed to crab")
+	bob.send("/topic")
+	bob.expect("Topic for crab: Ownership and borrowing\n")
+
+	// the last one out takes the topic with the group
+	alice.send("/leave")
+	alice.expect("You have left the group crab\n")
+	bob.send("/leave")
+	bob.expect("You have left the group crab\n")
+	bob.send("/join crab")
+	bob.expect("Created group crab\n")
+	bob.send("/topic")
+	bob.expect("No topic is set for crab.\n")
+}
+
+func TestJoinSendsRoster(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.expectQuiet("Users connected to")
+	alice.send("/topic Ownership")
+	alice.expect("set the topic: Ownership\n")
+	bob.send("/status coding")
+	bob.expect("Status set to: coding\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\nTopic: Ownership\n" +
+		"Users connected to rust:\n1. alice\n2. bob (coding)\n")
+
+	// the newcomer sees everyone who joined before them
+	carol.send("/join rust")
+	carol.expect("Successfully joined group rust\nTopic: Ownership\n" +
+		"Users connected to rust:\n1. alice\n2. bob (coding)\n3. carol\n")
+}
+
+func TestMyGroupsAndUserGroups(t *testing.T) {
+	s := newAdminServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/mygroups")
+	alice.expect("You are not part of any group.\n")
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/mygroups")
+	alice.expect("Your groups: rust\n")
+
+	bob.send("/usergroups alice")
+	bob.expect("Not authorized.\n")
+	bob.send("/auth hunter2")
+	bob.expect("admin")
+	bob.send("/usergroups alice")
+	bob.expect("alice's groups: rust\n")
+	bob.send("/usergroups #2")
+	bob.expect("bob is not part of any group.\n")
+	bob.send("/usergroups carol")
+	bob.expect("No user named carol is online.\n")
+}
@@ -0,0 +1,233 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chat-app-go/protocol"
+)
+
+// historyLine is one chat line kept for /export, /search and /reply.
+type historyLine struct {
+	at   time.Time
+	id   int64  // sequence number, shown as #id with Config.MessageIDs
+	from string // sender as displayed, e.g. alice or alice@east
+	text string
+	line string // as delivered, without the trailing newline
+}
+
+// chatLabel starts a chat line: "[rust] ", or "[rust #42] " with
+// Config.MessageIDs. Global chat has an empty group.
+func (s *Server) chatLabel(group string, id int64) string {
+	if group == "" {
+		group = "Global"
+	}
+	if s.config().MessageIDs {
+		return fmt.Sprintf("[%s #%d] ", group, id)
+	}
+	return "[" + group + "] "
+}
+
+// recordLocked appends a delivered line to the history of group (global
+// chat when empty), dropping the oldest once Config.HistorySize lines are
+// kept. Caller must hold lockClients (a read lock is enough); history has
+// its own mutex because Broadcast only holds the read lock.
+func (s *Server) recordLocked(group string, h historyLine) {
+	size := s.config().HistorySize
+	if size <= 0 {
+		return
+	}
+	if _, ok := s.groupsToClient[group]; !ok && group != "" {
+		return
+	}
+	h.at, h.line = time.Now(), strings.TrimSuffix(h.line, "\n")
+	s.historyMu.Lock()
+	hist := append(s.history[group], h)
+	if len(hist) > size {
+		hist = append(hist[:0:0], hist[len(hist)-size:]...)
+	}
+	s.history[group] = hist
+	s.historyMu.Unlock()
+}
+
+// historyOf returns a copy of group's history. Caller must hold lockClients.
+func (s *Server) historyOf(group string) []historyLine {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	return append([]historyLine(nil), s.history[group]...)
+}
+
+// moveHistoryLocked follows a group rename, or drops the history when to is
+// empty. Caller must hold lockClients for writing.
+func (s *Server) moveHistoryLocked(from, to string) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if h, ok := s.history[from]; ok && to != "" {
+		s.history[to] = h
+	}
+	delete(s.history, from)
+}
+
+// exportHistory handles "/export <group>": an admin, or with
+// Config.OwnerExport the group's owner, gets its history written to a new
+// timestamped file in Config.ExportDir, and the path back. Owners can't
+// export by default, since anyone can create a group and so write files to
+// the server's disk. Each export gets a file of its own, even two in the
+// same second. The file is written after the lock is released.
+func (s *Server) exportHistory(clientID int, args string) error {
+	group := strings.TrimSpace(args)
+	if group == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("export")+" <group>\n")
+	}
+	cfg := s.config()
+
+	s.lockClients.RLock()
+	_, exists := s.groupsToClient[group]
+	c := s.idToClient[clientID]
+	allowed := c != nil && (c.IsAdmin || cfg.OwnerExport && s.groupOwner[group] == clientID)
+	var lines []historyLine
+	if exists && allowed {
+		lines = s.historyOf(group)
+	}
+	s.lockClients.RUnlock()
+
+	switch {
+	case !exists:
+		return s.sendTo(clientID, "No such group\n")
+	case !allowed && cfg.OwnerExport:
+		return s.sendTo(clientID, "Only the owner of "+group+" or an admin can export it.\n")
+	case !allowed:
+		return s.sendTo(clientID, "Only an admin can export a group.\n")
+	}
+
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.at.Format(time.RFC3339) + " " + l.line + "\n")
+	}
+	// CreateTemp adds a random suffix and never opens an existing file
+	pattern := fmt.Sprintf("%s-%s-*.log", safeFileName(group), time.Now().Format("20060102-150405"))
+	f, err := os.CreateTemp(cfg.ExportDir, pattern)
+	if err == nil {
+		_, err = f.WriteString(b.String())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Println("export:", err)
+		return s.sendTo(clientID, "Export failed.\n")
+	}
+	return s.sendTo(clientID, fmt.Sprintf("Exported %d lines of %s to %s\n", len(lines), group, f.Name()))
+}
+
+// safeFileName keeps letters, digits, dashes and underscores so a group
+// name can't escape the export directory.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// limits for /search: long terms and huge buffers can't stall the server
+const (
+	maxSearchTermRunes = 64
+	maxSearchResults   = 20
+	maxSearchTime      = 50 * time.Millisecond
+)
+
+// searchHistory handles "/search <term>": it sends the caller the most
+// recent lines of their group's history that contain term, ignoring case.
+func (s *Server) searchHistory(clientID int, args string) error {
+	term := strings.TrimSpace(args)
+	if term == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("search")+" <term>\n")
+	}
+	if utf8.RuneCountInString(term) > maxSearchTermRunes {
+		return s.sendTo(clientID, fmt.Sprintf("Search terms can be at most %d characters.\n", maxSearchTermRunes))
+	}
+
+	s.lockClients.RLock()
+	group, inGroup := s.clientToGroup[clientID]
+	var lines []historyLine
+	if inGroup {
+		lines = s.historyOf(group)
+	}
+	s.lockClients.RUnlock()
+	if !inGroup {
+		return s.sendTo(clientID, "You are not part of any group.\n")
+	}
+
+	// newest first, so the cap keeps the most recent matches
+	needle := strings.ToLower(term)
+	deadline := time.Now().Add(maxSearchTime)
+	var matches []string
+	truncated := false
+	for i := len(lines) - 1; i >= 0; i-- {
+		if len(matches) == maxSearchResults || time.Now().After(deadline) {
+			truncated = true
+			break
+		}
+		if strings.Contains(strings.ToLower(lines[i].line), needle) {
+			matches = append(matches, lines[i].line)
+		}
+	}
+	if len(matches) == 0 {
+		return s.sendTo(clientID, "No matches for "+term+" in "+group+".\n")
+	}
+	slices.Reverse(matches)
+	out := "Matches for " + term + " in " + group + ":\n" + strings.Join(matches, "\n") + "\n"
+	if truncated {
+		out += "(showing the most recent matches only)\n"
+	}
+	return s.sendTo(clientID, out)
+}
+
+// how much of the original message /reply quotes
+const replySnippetRunes = 40
+
+// reply handles "/reply <id> <text>": it broadcasts text to the caller's
+// group (or global chat) with a short quote of message #id in front, as
+// long as that message is still in the same history.
+func (s *Server) reply(clientID int, args string) error {
+	cfg := s.config()
+	if !cfg.MessageIDs {
+		return s.sendTo(clientID, "Message IDs are off on this server.\n")
+	}
+	idStr, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if cfg.StripControl {
+		text = protocol.StripControl(text)
+	}
+	text = strings.TrimSpace(text)
+	msgID, err := strconv.ParseInt(strings.TrimPrefix(idStr, "#"), 10, 64)
+	if err != nil || text == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("reply")+" <id> <message>\n")
+	}
+
+	s.lockClients.RLock()
+	group, inGroup := s.clientToGroup[clientID]
+	lines := s.historyOf(group)
+	s.lockClients.RUnlock()
+
+	if !inGroup && cfg.GroupsOnly {
+		return s.sendTo(clientID, s.joinToChat())
+	}
+	i := slices.IndexFunc(lines, func(h historyLine) bool { return h.id == msgID })
+	if i < 0 {
+		return s.sendTo(clientID, fmt.Sprintf("Message #%d is not in the recent history here.\n", msgID))
+	}
+	quoted := lines[i].text
+	if short := truncateRunes(quoted, replySnippetRunes); short != quoted {
+		quoted = strings.TrimRight(short, " ") + "..."
+	}
+	s.Broadcast(clientID, fmt.Sprintf("(re #%d %s: \"%s\") %s", msgID, lines[i].from, quoted, text))
+	return nil
+}
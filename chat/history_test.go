@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportHistory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HistorySize = 2
+	cfg.ExportDir = t.TempDir()
+	cfg.OwnerExport = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	for _, msg := range []string{"one", "two", "three"} {
+		bob.send(msg)
+		alice.expect("[rust] bob: " + msg + "\n")
+	}
+
+	bob.send("/export rust")
+	bob.expect("Only the owner of rust or an admin can export it.\n")
+	alice.send("/export go")
+	alice.expect("No such group\n")
+
+	alice.send("/export rust")
+	alice.expect("Exported 2 lines of rust to " + cfg.ExportDir + "/rust-")
+	files, err := os.ReadDir(cfg.ExportDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("export dir holds %v (%v)", files, err)
+	}
+	b, err := os.ReadFile(filepath.Join(cfg.ExportDir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	if strings.Contains(got, "bob: one") || !strings.Contains(got, " [rust] bob: two\n") || !strings.Contains(got, " [rust] bob: three\n") {
+		t.Fatalf("export holds %q", got)
+	}
+}
+
+func TestExportAccess(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminPass = "hunter2"
+	cfg.ExportDir = t.TempDir()
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	admin := connect(t, s, "admin")
+
+	// owners can't write files to the server unless -owner-export is on
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	alice.send("/export rust")
+	alice.expect("Only an admin can export a group.\n")
+
+	// two exports in the same second get a file each
+	admin.send("/auth hunter2")
+	admin.expect("You are now an admin.\n")
+	admin.send("/export rust")
+	admin.expect("Exported 0 lines of rust")
+	admin.send("/export rust")
+	admin.expect("Exported 0 lines of rust")
+	files, err := os.ReadDir(cfg.ExportDir)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("export dir holds %v (%v)", files, err)
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/search")
+	alice.expect("Usage: /search <term>\n")
+	alice.send("/search needle")
+	alice.expect("You are not part of any group.\n")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	for _, msg := range []string{"Ferris is great", "lunch?", "ferris again"} {
+		bob.send(msg)
+		alice.expect("[rust] bob: " + msg + "\n")
+	}
+
+	alice.send("/search FERRIS")
+	alice.expect("Matches for FERRIS in rust:\n[rust] bob: Ferris is great\n[rust] bob: ferris again\n")
+	alice.send("/search dinner")
+	alice.expect("No matches for dinner in rust.\n")
+	alice.send("/search " + strings.Repeat("x", maxSearchTermRunes+1))
+	alice.expect("Search terms can be at most 64 characters.\n")
+
+	for i := 0; i < maxSearchResults+5; i++ {
+		bob.send("spam")
+		alice.expect("[rust] bob: spam\n")
+	}
+	alice.send("/search spam")
+	alice.expect("Matches for spam in rust:\n" + strings.Repeat("[rust] bob: spam\n", maxSearchResults) +
+		"(showing the most recent matches only)\n")
+}
+
+func TestReply(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MessageIDs = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	bob.send("Ferris is great, and this sentence goes on for quite a while")
+	alice.expect("[rust #1] bob: Ferris is great, and this sentence goes on for quite a while\n")
+
+	alice.send("/reply 1 agreed")
+	bob.expect("[rust #2] alice: (re #1 bob: \"Ferris is great, and this sentence goes...\") agreed\n")
+	bob.send("/reply #2 thanks")
+	alice.expect("[rust #3] bob: (re #2 alice: \"(re #1 bob: \"Ferris is great, and this s...\") thanks\n")
+
+	alice.send("/reply 99 hello")
+	alice.expect("Message #99 is not in the recent history here.\n")
+	alice.send("/reply one hello")
+	alice.expect("Usage: /reply <id> <message>\n")
+	alice.send("/leave")
+	alice.expect("You have left the group rust\n")
+	alice.send("/reply 1 hello")
+	alice.expect("Message #1 is not in the recent history here.\n")
+}
+
+func TestReplyNeedsMessageIDs(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+
+	alice.send("hi")
+	alice.send("/reply 1 hello")
+	alice.expect("Message IDs are off on this server.\n")
+}
@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// Middleware inspects a chat line from clientID before it is broadcast. It
+// returns the (possibly rewritten) line, or false to drop it. Middleware
+// runs in registration order; the first to drop a line stops the chain.
+type Middleware func(clientID int, msg string) (string, bool)
+
+// Use appends middleware to the inbound chat chain. The built-in groups-only
+// check, escape stripper, emoji expander and duplicate filter always come
+// first. Register middleware before calling Serve or HandleConn; the chain
+// is not guarded against concurrent changes.
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// filter passes a chat line through the middleware chain.
+func (s *Server) filter(clientID int, msg string) (string, bool) {
+	for _, mw := range s.middleware {
+		var ok bool
+		if msg, ok = mw(clientID, msg); !ok {
+			return "", false
+		}
+	}
+	return msg, true
+}
+
+// groupsOnlyMiddleware applies Config.GroupsOnly: a chat line from a user
+// outside any group is dropped, and they are told to join one instead.
+func (s *Server) groupsOnlyMiddleware(clientID int, msg string) (string, bool) {
+	if !s.config().GroupsOnly {
+		return msg, true
+	}
+	s.lockClients.RLock()
+	_, inGroup := s.clientToGroup[clientID]
+	s.lockClients.RUnlock()
+
+	if !inGroup {
+		_ = s.sendTo(clientID, s.joinToChat())
+	}
+	return msg, inGroup
+}
+
+// joinToChat is the reply to global chat under Config.GroupsOnly.
+func (s *Server) joinToChat() string {
+	return "Join a group to chat (" + s.cmdRef("join") + " <name>)\n"
+}
+
+// stripMiddleware applies Config.StripControl, line by line for multi-line
+// messages. A message that was nothing but escape sequences is dropped.
+func (s *Server) stripMiddleware(_ int, msg string) (string, bool) {
+	if !s.config().StripControl {
+		return msg, true
+	}
+	msg = protocol.StripControlLines(msg)
+	return msg, strings.Trim(msg, "\n") != ""
+}
+
+// emojiMiddleware applies Config.Emoji. Commands never reach the chain, so
+// their arguments are left alone.
+func (s *Server) emojiMiddleware(_ int, msg string) (string, bool) {
+	if !s.config().Emoji {
+		return msg, true
+	}
+	return expandShortcodes(msg), true
+}
+
+// dedupMiddleware applies Config.DedupWindow: a line identical to the
+// sender's previous one, arriving within the window of it, is dropped. The
+// window restarts with every repeat, so a client stuck resending a line
+// stays quiet however long it keeps at it; it is told once per run.
+func (s *Server) dedupMiddleware(clientID int, msg string) (string, bool) {
+	window := s.config().DedupWindow
+	if window <= 0 {
+		return msg, true
+	}
+	now := time.Now()
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	if c == nil {
+		s.lockClients.Unlock()
+		return "", false
+	}
+	dup := msg == c.lastLine && now.Sub(c.lastLineAt) < window
+	warn := dup && !c.dupWarned
+	c.lastLine, c.lastLineAt, c.dupWarned = msg, now, dup
+	s.lockClients.Unlock()
+
+	if warn {
+		_ = s.sendTo(clientID, "Duplicate message suppressed.\n")
+	}
+	return msg, !dup
+}
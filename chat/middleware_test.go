@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	s := newTestServer(t)
+	var order []string
+	s.Use(
+		func(_ int, msg string) (string, bool) {
+			order = append(order, "drop")
+			return msg, !strings.Contains(msg, "spam")
+		},
+		func(_ int, msg string) (string, bool) {
+			order = append(order, "shout")
+			return strings.ToUpper(msg), true
+		},
+	)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("hello \x1b[2Jthere")
+	bob.expect("[Global] alice: HELLO THERE\n")
+
+	alice.send("buy spam now")
+	alice.send("//slash")
+	bob.expect("[Global] alice: /SLASH\n")
+	bob.expectQuiet("SPAM")
+
+	// the first line ran through both, the dropped one stopped early
+	if got := strings.Join(order, ","); got != "drop,shout,drop,drop,shout" {
+		t.Errorf("middleware order = %s", got)
+	}
+
+	// commands bypass the chain
+	alice.send("/users")
+	alice.expect("Connected Users:\n")
+}
+
+func TestEmojiShortcodes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Emoji = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("ship it :rocket: :notacode:")
+	bob.expect("[Global] alice: ship it 🚀 :notacode:\n")
+
+	// commands are parsed before the chain runs
+	alice.send("/join :smile:")
+	alice.expect("Created group :smile:\n")
+}
+
+func TestDedupWindow(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DedupWindow = time.Hour
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("spam")
+	bob.expect("[Global] alice: spam\n")
+	alice.send("spam")
+	alice.expect("Duplicate message suppressed.\n")
+	alice.send("spam")
+	alice.send("eggs")
+	bob.expect("[Global] alice: eggs\n")
+	alice.expectQuiet("suppressed")
+	bob.expectQuiet("spam")
+
+	// a different line in between starts over
+	alice.send("spam")
+	bob.expect("[Global] alice: spam\n")
+}
+
+func TestGroupsOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.GroupsOnly = true
+	cfg.MessageIDs = true
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("hello everyone")
+	alice.expect("Join a group to chat (/join <name>)\n")
+	bob.expectQuiet("hello everyone")
+	alice.send("/reply 1 hi")
+	alice.expect("Join a group to chat (/join <name>)\n")
+
+	// listings still work outside a group
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n2. bob\n")
+	alice.send("/groups")
+	alice.expect("Available Groups:\n")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	alice.send("hello rust")
+	bob.expect("[rust #1] alice: hello rust\n")
+}
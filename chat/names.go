@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// word lists for Config.AnimalNames; 16x16 gives 256 names before suffixes
+var (
+	nameAdjectives = []string{
+		"Brave", "Calm", "Clever", "Cosy", "Eager", "Fuzzy", "Gentle", "Happy",
+		"Jolly", "Lucky", "Mellow", "Nimble", "Quiet", "Sunny", "Swift", "Witty",
+	}
+	nameAnimals = []string{
+		"Badger", "Beaver", "Falcon", "Ferret", "Fox", "Heron", "Koala", "Lynx",
+		"Marten", "Otter", "Owl", "Panda", "Puffin", "Robin", "Seal", "Wombat",
+	}
+)
+
+// animalNameLocked picks an unused name like BraveOtter for a client that
+// logged in without one, drawing again on a collision and falling back to a
+// numeric suffix once the draws keep colliding.
+// Caller must hold lockClients.
+func (s *Server) animalNameLocked() string {
+	var name string
+	for range 10 {
+		name = nameAdjectives[rand.IntN(len(nameAdjectives))] + nameAnimals[rand.IntN(len(nameAnimals))]
+		if !s.nameTakenLocked(name) {
+			return name
+		}
+	}
+	base := name
+	for n := 2; s.nameTakenLocked(name); n++ {
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	return name
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// offlineMsg is a direct message held for a user who wasn't online.
+type offlineMsg struct {
+	from, text string
+	at         time.Time
+}
+
+// queueOffline stores a DM for name. It first drops everything past
+// Config.OfflineTTL for every recipient, not just name, so queues for names
+// nobody ever logs in as don't linger. It returns why the message wasn't
+// queued, or "" if it was: the recipient's queue is full, or the server
+// already holds Config.OfflineTotal messages.
+func (s *Server) queueOffline(name, from, text string) string {
+	cfg := s.config()
+	s.lockClients.Lock()
+	defer s.lockClients.Unlock()
+
+	total := 0
+	for other := range s.offline {
+		total += len(s.pruneOfflineLocked(other, cfg.OfflineTTL))
+	}
+	q := s.offline[name]
+	switch {
+	case len(q) >= cfg.OfflineQueue:
+		return "too many messages are already waiting for " + name + "."
+	case cfg.OfflineTotal > 0 && total >= cfg.OfflineTotal:
+		return "the server is holding too many offline messages; try again later."
+	}
+	s.offline[name] = append(q, offlineMsg{from: from, text: text, at: time.Now()})
+	return ""
+}
+
+// pruneOfflineLocked drops expired messages for name and returns what's
+// left. Caller must hold lockClients for writing.
+func (s *Server) pruneOfflineLocked(name string, ttl time.Duration) []offlineMsg {
+	q := s.offline[name]
+	if ttl > 0 {
+		i := 0
+		for i < len(q) && time.Since(q[i].at) > ttl {
+			i++
+		}
+		q = q[i:]
+	}
+	if len(q) == 0 {
+		delete(s.offline, name)
+		return nil
+	}
+	s.offline[name] = q
+	return q
+}
+
+// deliverOffline sends clientID everything queued for its name while it was
+// away, oldest first, and empties the queue.
+func (s *Server) deliverOffline(clientID int) error {
+	s.lockClients.Lock()
+	var q []offlineMsg
+	if c := s.idToClient[clientID]; c != nil {
+		q = s.pruneOfflineLocked(c.Name, s.config().OfflineTTL)
+		delete(s.offline, c.Name)
+	}
+	s.lockClients.Unlock()
+
+	for _, m := range q {
+		ago := time.Since(m.at).Round(time.Second)
+		out := fmt.Sprintf(protocol.DMPrefix+"%s: %s (sent %s ago while you were offline)\n", m.from, m.text, ago)
+		if err := s.sendTo(clientID, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
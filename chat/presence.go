@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"strings"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// presenceLoop pushes group rosters every PresenceInterval until Close.
+func (s *Server) presenceLoop() {
+	ticker := time.NewTicker(s.config().PresenceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.presenceTick()
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// presenceTick sends each group whose membership changed since the last
+// tick its current roster. Only presenceLoop calls it, so lastRoster needs
+// no lock; the group tables are snapshotted under the read lock.
+func (s *Server) presenceTick() {
+	type roster struct {
+		line    string
+		members []int
+	}
+	changed := make(map[string]roster)
+
+	s.lockClients.RLock()
+	for grp, ids := range s.groupsToClient {
+		names := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if c := s.idToClient[id]; c != nil {
+				names = append(names, c.Name)
+			}
+		}
+		sortNames(names)
+		line := protocol.RosterPrefix + grp + ": " + strings.Join(names, ", ") + "\n"
+		if s.lastRoster[grp] != line {
+			changed[grp] = roster{line: line, members: append([]int(nil), ids...)}
+		}
+	}
+	for grp := range s.lastRoster {
+		if _, ok := s.groupsToClient[grp]; !ok {
+			delete(s.lastRoster, grp)
+		}
+	}
+	s.lockClients.RUnlock()
+
+	for grp, r := range changed {
+		s.lastRoster[grp] = r.line
+		s.broadcast(r.members, r.line, -1)
+	}
+}
@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"testing"
+
+	"chat-app-go/protocol"
+)
+
+func TestPresenceTickSendsChangedRosters(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	s.presenceTick()
+	alice.expect(protocol.RosterPrefix + "rust: alice, bob\n")
+	bob.expect(protocol.RosterPrefix + "rust: alice, bob\n")
+	carol.expectQuiet(protocol.RosterPrefix)
+
+	// nothing changed: nothing sent
+	s.presenceTick()
+	alice.expectQuiet(protocol.RosterPrefix)
+
+	bob.send("/leave")
+	bob.expect("You have left the group rust\n")
+	s.presenceTick()
+	alice.expect(protocol.RosterPrefix + "rust: alice\n")
+	bob.expectQuiet(protocol.RosterPrefix)
+}
@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// errResumed closes a stale connection whose session was picked up by a
+// new one.
+var errResumed = errors.New("session resumed on a new connection")
+
+// session remembers who held a resume token. While its client is connected
+// expires is zero; closeClient fills in the group and the deadline.
+type session struct {
+	clientID int
+	name     string
+	group    string
+	expires  time.Time
+}
+
+// issueTokenLocked gives a client that just logged in a resume token, or
+// returns "" with Config.ResumeTTL unset.
+// Caller must hold lockClients for writing.
+func (s *Server) issueTokenLocked(c *Client) string {
+	if s.config().ResumeTTL <= 0 {
+		return ""
+	}
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	c.resumeToken = token
+	s.sessions[token] = &session{clientID: c.ID, name: c.Name}
+	return token
+}
+
+// tokenNotice is the welcome's last line, telling the user their token.
+func (s *Server) tokenNotice(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "Session token: " + token + " (after a reconnect, " + s.cmdRef("resume") + " " + token + " puts you back in your group)\n"
+}
+
+// parkSessionLocked keeps a departing client's session for Config.ResumeTTL,
+// remembering the group it was in, and forgets expired ones.
+// Caller must hold lockClients for writing.
+func (s *Server) parkSessionLocked(c *Client, group string) {
+	now := time.Now()
+	for token, ss := range s.sessions {
+		if !ss.expires.IsZero() && now.After(ss.expires) {
+			delete(s.sessions, token)
+		}
+	}
+	if ss := s.sessions[c.resumeToken]; ss != nil && ss.clientID == c.ID {
+		ss.group, ss.expires = group, now.Add(s.config().ResumeTTL)
+	}
+}
+
+// resume handles "/resume <token>": the caller takes over the session's
+// name and, if it still exists, its group. A connection still holding the
+// session (one that went half-open, say) is closed first so the two never
+// share it.
+func (s *Server) resume(clientID int, args string) error {
+	token := strings.TrimSpace(args)
+	if token == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("resume")+" <token>\n")
+	}
+
+	s.lockClients.RLock()
+	ss := s.sessions[token]
+	var stale int
+	if ss != nil && ss.expires.IsZero() {
+		stale = ss.clientID
+	}
+	s.lockClients.RUnlock()
+	if ss == nil || stale == clientID {
+		return s.sendTo(clientID, "Unknown or expired session token.\n")
+	}
+	if stale != 0 {
+		s.closeClient(stale, errResumed)
+	}
+
+	s.lockClients.Lock()
+	ss = s.sessions[token]
+	if ss == nil || ss.expires.IsZero() || time.Now().After(ss.expires) {
+		s.lockClients.Unlock()
+		return s.sendTo(clientID, "Unknown or expired session token.\n")
+	}
+	delete(s.sessions, token)
+	c := s.idToClient[clientID]
+	if c == nil {
+		s.lockClients.Unlock()
+		return errClientGone
+	}
+	c.Name = ss.name
+	if own := s.sessions[c.resumeToken]; own != nil {
+		own.name = ss.name
+	}
+	_, inGroup := s.clientToGroup[clientID]
+	members, exists := s.groupsToClient[ss.group]
+	var reply string
+	var notify []int
+	switch {
+	case ss.group == "":
+		reply = "Resumed your session as " + ss.name + ".\n"
+	case inGroup:
+		reply = "Resumed your session as " + ss.name + "; you are already in a group, so you stay there.\n"
+	case !exists:
+		reply = "Resumed your session as " + ss.name + ", but " + ss.group + " no longer exists; you are not in a group.\n"
+	case s.groupLimitLocked(ss.group) > 0 && len(members) >= s.groupLimitLocked(ss.group):
+		reply = "Resumed your session as " + ss.name + ", but " + ss.group + " is full now.\n"
+	default:
+		notify = append([]int(nil), members...)
+		if _, owned := s.groupOwner[ss.group]; !owned {
+			s.groupOwner[ss.group] = clientID
+		}
+		s.groupsToClient[ss.group] = append(members, clientID)
+		s.clientToGroup[clientID] = ss.group
+		reply = "Resumed your session as " + ss.name + "; you are back in " + ss.group + ".\n"
+	}
+	s.lockClients.Unlock()
+
+	s.broadcast(notify, "["+ss.group+"] "+ss.name+" reconnected.\n", -1)
+	return s.sendTo(clientID, reply)
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+// loginWithToken logs in as name and returns the session token from the
+// welcome banner.
+func loginWithToken(t *testing.T, s *Server, name string) (*testClient, string) {
+	t.Helper()
+	tc := dial(t, s)
+	tc.expect("Please enter your username: ")
+	tc.send(name)
+	tc.expect("Session token: ")
+	tc.skip()
+	token, _, _ := strings.Cut(tc.pending, " ")
+	tc.pending = ""
+	return tc, token
+}
+
+func TestResumeRejoinsGroup(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob, token := loginWithToken(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	bob.conn.Close()
+	alice.expect("[rust] bob disconnected")
+
+	again := connect(t, s, "bob2")
+	again.send("/resume nope")
+	again.expect("Unknown or expired session token.\n")
+	again.send("/resume " + token)
+	again.expect("Resumed your session as bob; you are back in rust.\n")
+	alice.expect("[rust] bob reconnected.\n")
+	again.send("hello")
+	alice.expect("[rust] bob: hello\n")
+
+	other := connect(t, s, "mallory")
+	other.send("/resume " + token)
+	other.expect("Unknown or expired session token.\n")
+}
+
+func TestResumeClosesStaleConnection(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	carol, token := loginWithToken(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	carol.send("/join rust")
+	carol.expect("Successfully joined group rust\n")
+
+	again := connect(t, s, "carol2")
+	again.send("/resume " + token)
+	carol.expectClosed()
+	alice.expect("[rust] carol disconnected (session resumed on a new connection)\n")
+	again.expect("Resumed your session as carol; you are back in rust.\n")
+}
+
+func TestResumeAfterGroupIsGone(t *testing.T) {
+	s := newTestServer(t)
+	bob, token := loginWithToken(t, s, "bob")
+	bob.send("/join rust")
+	bob.expect("Created group rust\n")
+	bob.conn.Close()
+
+	again := connect(t, s, "bob2")
+	again.send("/resume " + token)
+	again.expect("Resumed your session as bob, but rust no longer exists; you are not in a group.\n")
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"strings"
+	"time"
+)
+
+// how many departed users /seen remembers; the longest-gone are forgotten first
+const lastSeenLimit = 10000
+
+// touch records activity from a client. It runs for every line received,
+// so it stores atomically rather than taking lockClients.
+func (s *Server) touch(c *Client) {
+	c.LastActive.Store(time.Now().UnixNano())
+}
+
+// lastActive is when c last sent a line, or logged in if it hasn't.
+func (c *Client) lastActive() time.Time {
+	return time.Unix(0, c.LastActive.Load())
+}
+
+// rememberLocked stores when a departing user was last active, evicting the
+// oldest entry once the table is full. Caller must hold lockClients for writing.
+func (s *Server) rememberLocked(name string, at time.Time) {
+	if _, ok := s.lastSeen[name]; !ok && len(s.lastSeen) >= lastSeenLimit {
+		oldest, oldestAt := "", at
+		for n, t := range s.lastSeen {
+			if t.Before(oldestAt) {
+				oldest, oldestAt = n, t
+			}
+		}
+		delete(s.lastSeen, oldest)
+	}
+	s.lastSeen[name] = at
+}
+
+// seen handles "/seen <user>".
+func (s *Server) seen(clientID int, args string) error {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return s.sendTo(clientID, "Usage: "+s.cmdRef("seen")+" <username>\n")
+	}
+
+	s.lockClients.RLock()
+	var online bool
+	var at time.Time
+	if c := s.findClientLocked(name); c != nil {
+		online, at = true, c.lastActive()
+	} else {
+		at = s.lastSeen[name]
+	}
+	s.lockClients.RUnlock()
+
+	ago := time.Since(at).Round(time.Second).String()
+	switch {
+	case online:
+		return s.sendTo(clientID, name+" is online now (last active "+ago+" ago).\n")
+	case at.IsZero():
+		return s.sendTo(clientID, "I haven't seen "+name+".\n")
+	default:
+		return s.sendTo(clientID, name+" was last seen "+ago+" ago.\n")
+	}
+}
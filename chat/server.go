@@ -0,0 +1,774 @@
+// Package chat implements the chat server: connection handling, client and
+// group bookkeeping, and the slash-command protocol.
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-app-go/protocol"
+)
+
+// Config holds the tunable server settings.
+type Config struct {
+	MaxNameRunes    int  // username cap
+	MaxMessageRunes int  // chat message cap
+	Acks            bool // confirm (or report failure of) each direct message to its sender
+	AllowGuests     bool // name blank logins Guest<ID> instead of rejecting them
+	AnimalNames     bool // name blank logins like BraveOtter instead; implies AllowGuests
+	MaxGroupSize    int  // default member cap per group; 0 means unlimited
+	MaxGroups       int  // cap on groups in existence; 0 means unlimited
+	StrictGroups    bool // only Groups may be joined; users can't create or rename groups
+	GroupsOnly      bool // no global chat: users outside a group are told to join one
+	Compress        bool // deflate sessions for clients that ask for it
+	StripControl    bool // remove escape sequences and control characters from relayed messages
+	Echo            bool // send chat lines back to their sender too; clients can override with /echo
+	Emoji           bool // expand :smile:-style shortcodes in chat lines
+
+	TypingInterval time.Duration // minimum gap between relayed typing notices per client
+	NickCooldown   time.Duration // minimum gap between successful /nick changes per client
+	DedupWindow    time.Duration // drop a chat line repeating the sender's previous one within this long; 0 disables
+	KeepAlive      time.Duration // idle time before TCP keepalive probes start; 0 disables keepalive
+	ProbeInterval  time.Duration // gap between unanswered keepalive probes; 0 uses KeepAlive
+	ProbeCount     int           // unanswered probes before the connection is dropped; 0 uses the OS default
+	WriteTimeout   time.Duration // per-write deadline; a recipient slower than this is dropped
+	AckTimeout     time.Duration // with Acks, how long a direct message's write is waited for; 0 waits as long as it takes
+	LoginTimeout   time.Duration // how long a new connection may take to send its username; 0 waits forever
+	QueueSize      int           // outbound messages buffered per client before it is dropped
+	ReadBuffer     int           // initial read buffer per connection in bytes; longer lines still arrive whole
+
+	ShutdownTimeout time.Duration // how long Run waits for connections to finish after its context ends
+
+	PresenceInterval time.Duration // how often changed group rosters are pushed; 0 disables
+
+	GroupCreateLimit  int           // groups one client may create per GroupCreateWindow; 0 means unlimited
+	GroupCreateWindow time.Duration // sliding window for GroupCreateLimit
+
+	Groups []string // created by New and kept even when empty; read only at startup
+
+	HistorySize int    // chat lines kept per group for /export, /search and /reply; 0 disables
+	MessageIDs  bool   // show each chat line's #id so users can /reply to it
+	ExportDir   string // where /export writes its files
+	OwnerExport bool   // let group owners, not just admins, /export their group
+
+	OfflineQueue int           // DMs held per offline user; 0 disables store-and-forward
+	OfflineTotal int           // DMs held for all offline users together; 0 means unlimited
+	OfflineTTL   time.Duration // queued DMs older than this are discarded; 0 keeps them
+
+	ResumeTTL time.Duration // how long /resume can pick up a session after a disconnect; 0 disables tokens
+
+	AdminPass string // password for /auth; empty disables admin commands
+
+	MOTDReplacesHelp bool // a message of the day replaces the command list instead of following it
+
+	CommandPrefix string // starts a command line, "/" by default
+
+	NodeName   string // this server's name in the federation; shown as user@node on other servers
+	PeerSecret string // shared secret for server links; empty refuses incoming links
+}
+
+// DefaultConfig returns the settings the server uses when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		MaxNameRunes:    32,
+		MaxMessageRunes: 1000,
+		StripControl:    true,
+		TypingInterval:  3 * time.Second,
+		NickCooldown:    30 * time.Second,
+		KeepAlive:       30 * time.Second,
+		ProbeInterval:   10 * time.Second,
+		ProbeCount:      3,
+		WriteTimeout:    5 * time.Second,
+		AckTimeout:      5 * time.Second,
+		LoginTimeout:    30 * time.Second,
+		QueueSize:       64,
+		ReadBuffer:      4096,
+		ShutdownTimeout: 5 * time.Second,
+		OfflineTTL:      24 * time.Hour,
+		OfflineTotal:    10000,
+		ResumeTTL:       5 * time.Minute,
+		MaxGroups:       1000,
+		HistorySize:     100,
+		ExportDir:       ".",
+		CommandPrefix:   "/",
+
+		GroupCreateLimit:  5,
+		GroupCreateWindow: time.Minute,
+	}
+}
+
+type Client struct {
+	Name string
+	ID   int
+	Conn net.Conn
+	Addr string // remote address at accept time, for admins
+
+	ClientVersion string // build reported by the client's hello, if any; guarded by lockClients
+	clientProto   int    // protocol.Version the client reported; guarded by lockClients
+	multiline     bool   // the hello asked for continued lines; set during login, guarded by lockClients
+
+	out  chan outMsg   // queued writes, drained by writeLoop
+	done chan struct{} // closed by closeClient
+
+	lastTyping time.Time // last relayed /typing signal
+	lastNick   time.Time // last successful /nick; guarded by lockClients
+
+	lastLine   string    // last chat line seen, for Config.DedupWindow; guarded by lockClients
+	lastLineAt time.Time // when lastLine was last sent
+	dupWarned  bool      // told about the current run of duplicates already
+	IsAdmin    bool      // passed /auth; guarded by lockClients
+	Echo       bool      // receives their own chat lines; guarded by lockClients
+	Status     string    // shown next to the name in /users, set with /status; guarded by lockClients
+	away       bool      // Status was set by /away; guarded by lockClients
+	awayFrom   string    // Status before /away, put back by /back; guarded by lockClients
+
+	groupCreates []time.Time // recent group creations, for GroupCreateLimit; guarded by lockClients
+	authFailures int         // wrong /auth passwords so far, for maxAuthFailures; guarded by lockClients
+
+	resumeToken string          // this session's /resume token; guarded by lockClients
+	blocked     map[string]bool // /block: name -> DMs only; guarded by lockClients
+
+	Connected   time.Time    // when the connection was accepted; set once by register
+	BytesIn     atomic.Int64 // lines read from the client, line endings included
+	BytesOut    atomic.Int64 // bytes written to the client, before compression
+	MessagesIn  atomic.Int64 // messages read from the client, commands and the username included
+	MessagesOut atomic.Int64 // messages written to the client
+	LastActive  atomic.Int64 // unix nanoseconds of the last line received; see touch
+}
+
+// Server holds all shared chat state. Every map and slice below is guarded
+// by lockClients: mutations take the write lock, read-only paths (listings,
+// name lookups, recipient snapshots) take the read lock.
+//
+// sendTo, sendToWait, broadcast and closeClient take lockClients
+// themselves, and sync.RWMutex is not reentrant. A handler therefore never
+// calls them, or anything that calls them, with the lock held: it copies
+// what it needs, unlocks, then sends, and returns a failed send's error for
+// the caller to close the client with. TestHandlersSurviveFailedWrites runs
+// every command against a client whose writes all fail.
+type Server struct {
+	cfg atomic.Pointer[Config] // swapped whole by Reload; read through config()
+
+	lockClients    sync.RWMutex
+	clientList     []*Client               // named clients, in join order
+	groupsToClient map[string][]int        // group -> []clientID
+	clientToGroup  map[int]string          // clientID -> group
+	groupOwner     map[string]int          // group -> owning clientID
+	groupLimit     map[string]int          // group -> member cap set with /setlimit
+	groupTopic     map[string]string       // group -> description set with /topic
+	fixedGroups    map[string]bool         // Config.Groups; never removed when empty
+	idToClient     map[int]*Client         // clientID -> ptr (includes clients still picking a name)
+	offline        map[string][]offlineMsg // username -> DMs waiting for them to log in
+	peers          map[int]string          // clientID of a server link -> peer node name
+	lastSeen       map[string]time.Time    // departed username -> last activity, for /seen
+	sessions       map[string]*session     // resume token -> session, kept ResumeTTL after disconnect
+	accounts       accountStore            // registered names; has its own mutex
+	listeners      []net.Listener          // every listener passed to Serve, closed by Close
+
+	started    time.Time    // for /stats uptime
+	clientSeq  atomic.Int64 // last client ID handed out; IDs start at 1
+	totalConns atomic.Int64 // connections handled since start
+	bytesIn    atomic.Int64 // Client.BytesIn summed over every connection
+	bytesOut   atomic.Int64 // Client.BytesOut summed over every connection
+
+	closing   chan struct{} // closed by Close; stops background loops
+	closeOnce sync.Once
+	conns     sync.WaitGroup // each connection's reader and writer; see track
+
+	presenceOnce sync.Once // the first Serve starts presenceLoop
+
+	lastRoster map[string]string // group -> last pushed roster; presenceLoop only
+
+	motd atomic.Pointer[string] // message of the day; swapped by SetMOTD
+
+	middleware []Middleware // inbound chat chain; see Use
+
+	historyMu sync.Mutex
+	history   map[string][]historyLine // group ("" for global) -> recent chat lines, oldest first
+	msgSeq    atomic.Int64             // numbers chat lines for /reply
+
+	relaySeq   atomic.Int64 // numbers chat lines relayed to peers
+	relayMu    sync.Mutex
+	relaySeen  map[string]bool // federation message IDs already handled
+	relayOrder []string        // relaySeen keys, oldest first, for eviction
+}
+
+// New returns a Server with empty client and group tables.
+func New(cfg Config) *Server {
+	s := &Server{
+		groupsToClient: make(map[string][]int),
+		clientToGroup:  make(map[int]string),
+		groupOwner:     make(map[string]int),
+		groupLimit:     make(map[string]int),
+		groupTopic:     make(map[string]string),
+		idToClient:     make(map[int]*Client),
+		offline:        make(map[string][]offlineMsg),
+		peers:          make(map[int]string),
+		lastSeen:       make(map[string]time.Time),
+		sessions:       make(map[string]*session),
+		relaySeen:      make(map[string]bool),
+		started:        time.Now(),
+		closing:        make(chan struct{}),
+		lastRoster:     make(map[string]string),
+		fixedGroups:    make(map[string]bool),
+		history:        make(map[string][]historyLine),
+	}
+	for _, grp := range cfg.Groups {
+		if reservedGroupName(grp) {
+			fmt.Printf("not creating group %s: the name is reserved\n", grp)
+			continue
+		}
+		s.groupsToClient[grp] = []int{}
+		s.fixedGroups[grp] = true
+	}
+	s.cfg.Store(&cfg)
+	s.Use(s.groupsOnlyMiddleware, s.stripMiddleware, s.emojiMiddleware, s.dedupMiddleware)
+	return s
+}
+
+// config returns the current settings. Callers that read several fields
+// should keep the returned pointer so a concurrent Reload can't mix values.
+func (s *Server) config() *Config {
+	return s.cfg.Load()
+}
+
+// Reload swaps in new settings without dropping anyone. Message and name
+// caps, acks, guests, groups-only, group size, typing interval, write
+// timeout, admin password and escape stripping take effect at once;
+// keepalive, queue size, read buffer and compression apply to connections
+// accepted afterwards. The presence interval is only read when Serve
+// starts, and Groups only by New.
+func (s *Server) Reload(cfg Config) {
+	s.cfg.Store(&cfg)
+}
+
+// Serve accepts connections on ln until it is closed, running each client
+// in its own goroutine. It may run once per listener at the same time; see
+// ServeAll.
+func (s *Server) Serve(ln net.Listener) error {
+	s.lockClients.Lock()
+	select {
+	case <-s.closing:
+		s.lockClients.Unlock()
+		_ = ln.Close()
+		return net.ErrClosed
+	default:
+	}
+	s.listeners = append(s.listeners, ln)
+	s.lockClients.Unlock()
+
+	s.presenceOnce.Do(func() {
+		if s.config().PresenceInterval > 0 {
+			go s.presenceLoop()
+		}
+	})
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// likely listener closed on shutdown
+			return err
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			_ = tc.SetKeepAliveConfig(s.keepAliveConfig())
+		}
+		go s.HandleConn(conn)
+	}
+}
+
+// ServeAll runs Serve on every listener at once and waits for all of them
+// to stop. A listener that fails while the server isn't shutting down is
+// logged when it happens, and the returned error lists each listener's
+// error by address.
+func (s *Server) ServeAll(lns ...net.Listener) error {
+	errs := make([]error, len(lns))
+	var wg sync.WaitGroup
+	for i, ln := range lns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Serve(ln)
+			select {
+			case <-s.closing:
+			default:
+				fmt.Printf("listener %s stopped: %v\n", ln.Addr(), err)
+			}
+			errs[i] = fmt.Errorf("%s: %w", ln.Addr(), err)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Run serves lns like ServeAll until ctx is cancelled, then shuts the server
+// down, waiting up to ShutdownTimeout, and returns nil. A listener failing
+// on its own still returns ServeAll's error. It lets an embedding program
+// shut the server down by cancelling a context instead of exiting the
+// process.
+func (s *Server) Run(ctx context.Context, lns ...net.Listener) error {
+	drained := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		s.Shutdown(s.config().ShutdownTimeout)
+		close(drained)
+	})
+	defer stop()
+	err := s.ServeAll(lns...)
+	if ctx.Err() != nil {
+		<-drained
+		return nil
+	}
+	return err
+}
+
+// keepAliveConfig turns the keepalive settings into socket options: after
+// KeepAlive of silence the OS sends a probe every ProbeInterval and resets
+// the connection once ProbeCount probes go unanswered. The blocked read in
+// clientRoutine then fails and the client is closed like any other
+// disconnect, so a peer that vanished without a FIN is gone within
+// KeepAlive + ProbeInterval*ProbeCount.
+func (s *Server) keepAliveConfig() net.KeepAliveConfig {
+	cfg := s.config()
+	if cfg.KeepAlive <= 0 {
+		return net.KeepAliveConfig{Enable: false}
+	}
+	interval := cfg.ProbeInterval
+	if interval <= 0 {
+		interval = cfg.KeepAlive
+	}
+	return net.KeepAliveConfig{Enable: true, Idle: cfg.KeepAlive, Interval: interval, Count: cfg.ProbeCount}
+}
+
+// how long Close waits on each client for the shutdown notice to go out
+const shutdownNoticeTimeout = time.Second
+
+// Close stops the listener, tells every connected client the server is going
+// away, and drops them.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closing) })
+
+	s.lockClients.Lock()
+	defer s.lockClients.Unlock()
+
+	for _, ln := range s.listeners {
+		_ = ln.Close()
+	}
+	for _, c := range s.idToClient {
+		if c.Conn != nil {
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(shutdownNoticeTimeout))
+			_ = writeFull(c.Conn, []byte("Server is shutting down.\n"))
+			_ = c.Conn.Close()
+		}
+	}
+}
+
+// Shutdown closes the server like Close, then waits up to timeout for every
+// connection's goroutines to return, so none is cut off mid-write. It logs
+// how many clients were connected and reports whether all of them finished
+// in time.
+func (s *Server) Shutdown(timeout time.Duration) bool {
+	s.lockClients.RLock()
+	n := len(s.clientList)
+	s.lockClients.RUnlock()
+	fmt.Printf("shutting down with %d clients connected\n", n)
+	s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		fmt.Printf("shutdown: connections still open after %v\n", timeout)
+		return false
+	}
+}
+
+// track counts one more connection goroutine for Shutdown to wait on; the
+// caller must call s.conns.Done when it returns. Once the server is closing
+// it refuses, so an Add can never race Shutdown's Wait.
+func (s *Server) track() bool {
+	s.lockClients.Lock()
+	defer s.lockClients.Unlock()
+	select {
+	case <-s.closing:
+		return false
+	default:
+		s.conns.Add(1)
+		return true
+	}
+}
+
+// SetMOTD sets the message of the day sent after login. An empty text
+// restores the built-in banner. Safe to call while serving, e.g. on SIGHUP.
+func (s *Server) SetMOTD(text string) {
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	s.motd.Store(&text)
+}
+
+// welcome builds the banner for a freshly logged-in client.
+func (s *Server) welcome(name string) string {
+	var motd string
+	if p := s.motd.Load(); p != nil {
+		motd = *p
+	}
+	if motd != "" && s.config().MOTDReplacesHelp {
+		return "Welcome " + name + "!\n" + motd
+	}
+	return "Welcome " + name + "! You can use the following commands:\n" + helpText(s.config().CommandPrefix) + motd
+}
+
+// HandleConn registers conn as a new client and serves it until it
+// disconnects. It blocks for the lifetime of the connection.
+func (s *Server) HandleConn(conn net.Conn) {
+	if !s.track() {
+		_ = conn.Close()
+		return
+	}
+	defer s.conns.Done()
+	s.totalConns.Add(1)
+	if s.config().Compress {
+		conn = negotiateCompression(conn)
+	}
+	c := s.register(conn)
+	if c == nil {
+		return
+	}
+	s.clientRoutine(c.ID)
+}
+
+// register gives conn a client ID and starts its writer. It closes conn
+// and returns nil if the server is shutting down.
+func (s *Server) register(conn net.Conn) *Client {
+	if !s.track() {
+		_ = conn.Close()
+		return nil
+	}
+	myID := s.newClientID()
+	c := &Client{
+		Name:      "",
+		ID:        myID,
+		Conn:      conn,
+		out:       make(chan outMsg, s.config().QueueSize),
+		done:      make(chan struct{}),
+		Echo:      s.config().Echo,
+		Connected: time.Now(),
+	}
+	if ra := conn.RemoteAddr(); ra != nil {
+		c.Addr = ra.String()
+	}
+	s.lockClients.Lock()
+	s.idToClient[myID] = c
+	s.lockClients.Unlock()
+
+	go s.writeLoop(c)
+	return c
+}
+
+// newClientID returns the next client ID. It needs no lock, so minting
+// one doesn't contend with chat traffic on lockClients.
+func (s *Server) newClientID() int {
+	return int(s.clientSeq.Add(1))
+}
+
+// errEmptyName ends a login that sent a blank name while guests are off.
+var errEmptyName = errors.New("empty username")
+
+// closeClient drops a client. reason is what ended the session; it is
+// logged, and members of the client's group are told who left and why.
+// Several goroutines may close the same client at once (its reader, a
+// broadcast whose write failed, an admin): the first to remove it from
+// idToClient under the write lock does the work, and the rest find it
+// gone and return.
+func (s *Server) closeClient(clientID int, reason error) {
+	s.lockClients.Lock()
+	c := s.idToClient[clientID]
+	if c == nil {
+		s.lockClients.Unlock()
+		return
+	}
+	close(c.done)
+	if c.Conn != nil {
+		_ = c.Conn.Close()
+	}
+
+	// remove from clientList
+	for i := range s.clientList {
+		if s.clientList[i].ID == clientID {
+			s.clientList = append(s.clientList[:i], s.clientList[i+1:]...)
+			break
+		}
+	}
+
+	// remove from group mappings
+	grp, inGroup := s.removeFromGroupLocked(clientID)
+	if c.resumeToken != "" {
+		s.parkSessionLocked(c, grp)
+	}
+	var members []int
+	if inGroup {
+		members = append(members, s.groupsToClient[grp]...)
+	}
+
+	delete(s.idToClient, clientID)
+	name := c.Name
+	if name != "" {
+		s.rememberLocked(name, c.lastActive())
+	}
+	s.lockClients.Unlock()
+
+	if name == "" {
+		return // never logged in
+	}
+	why := disconnectReason(reason)
+	fmt.Printf("%s disconnected: %s\n", name, why)
+	notice := "[" + grp + "] " + name + " disconnected (" + why + ")\n"
+	s.broadcast(members, notice, -1)
+}
+
+// disconnectReason turns the error that ended a session into a short
+// explanation for logs and leave notices.
+func disconnectReason(err error) string {
+	switch {
+	case err == nil:
+		return "closed"
+	case errors.Is(err, errQuit):
+		return "quit"
+	case errors.Is(err, io.EOF):
+		return "connection closed by client"
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		return "connection closed"
+	case errors.Is(err, errQueueFull):
+		return "too slow to keep up"
+	case errors.Is(err, errLineTooLong):
+		return "sent an oversized line"
+	case errors.Is(err, errBinaryData):
+		return "sent binary data"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timed out"
+	}
+	return err.Error()
+}
+
+// frameLimit is the longest line a client may send: a -maxmsg message of
+// four-byte runes plus frameOverhead, and never more than maxLineBytes. A
+// longer line can't be a chat message, so the connection is dropped before
+// it is buffered any further.
+func (s *Server) frameLimit() int {
+	n := s.config().MaxMessageRunes
+	if n <= 0 || n > (maxLineBytes-frameOverhead)/utf8.UTFMax {
+		return maxLineBytes
+	}
+	return n*utf8.UTFMax + frameOverhead
+}
+
+// logProtocolError notes where a line that broke the protocol came from,
+// since the client may not have got as far as a name.
+func (s *Server) logProtocolError(c *Client, err error) {
+	if errors.Is(err, errLineTooLong) || errors.Is(err, errBinaryData) {
+		fmt.Printf("protocol error from %s: %v\n", c.Addr, err)
+	}
+}
+
+// loginReadFailed ends a connection whose read failed before login,
+// telling it if it was too slow to answer the prompt.
+func (s *Server) loginReadFailed(c *Client, err error) {
+	s.logProtocolError(c, err)
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		_ = s.sendToWait(c.ID, "Username prompt timed out.\n")
+	}
+	s.closeClient(c.ID, err)
+}
+
+// nameProblem explains why name can't be a username, or returns "" if it
+// can. A name may not start with or contain the command prefix, so it can't
+// be mistaken for a command, nor contain ':', which would break the
+// "name: text" rendering of chat lines, nor control characters. Callers
+// trim and truncate the name to MaxNameRunes first.
+func nameProblem(name, prefix string) string {
+	switch {
+	case strings.HasPrefix(name, prefix):
+		return "Usernames can't start with " + prefix + "."
+	case strings.Contains(name, prefix):
+		return "Usernames can't contain " + prefix + "."
+	case strings.Contains(name, ":"):
+		return "Usernames can't contain ':'."
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "Usernames can't contain control characters."
+	}
+	return ""
+}
+
+// awaitName runs the first of a connection's two states: until it has a
+// usable username, every line it sends is a username attempt, never a
+// command or chat. The one exception is "/login <name> <password>" for a
+// registered account. A registered name sent without it, or one that
+// nameProblem rejects, is refused and asked for again. Names longer than
+// MaxNameRunes are cut to fit, and the line itself is bounded by
+// frameLimit like any other. All attempts share one LoginTimeout, so a
+// silent or stubborn connection can't hold its slot forever. An empty name
+// is returned for a guest login. ok is false if the connection was closed
+// or turned out to be a server link, which acceptPeer has then served.
+// deadline is the one clientRoutine computed from LoginTimeout, already
+// used by detectFraming; zero means no limit.
+func (s *Server) awaitName(c *Client, reader *bufio.Reader, deadline time.Time) (name string, ok bool) {
+	cfg := s.config()
+	_ = c.Conn.SetReadDeadline(deadline)
+	for {
+		line, err := readLine(reader, s.frameLimit())
+		if err != nil {
+			s.loginReadFailed(c, err)
+			return "", false
+		}
+		s.countIn(c, line)
+		if strings.HasPrefix(line, peerHello) {
+			_ = c.Conn.SetReadDeadline(time.Time{})
+			s.acceptPeer(c.ID, reader, line)
+			return "", false
+		}
+		line = stripHello(line)
+		if strings.HasPrefix(line, protocol.ClientHello) {
+			if err := s.acceptClientHello(c, line); err != nil {
+				s.closeClient(c.ID, err)
+				return "", false
+			}
+			continue
+		}
+		if cmd, args, ok := parseCommand(line, cfg.CommandPrefix); ok && cmd == "login" && s.accounts.enabled() {
+			user, password, _ := strings.Cut(args, " ")
+			if s.accounts.check(user, strings.TrimSpace(password)) {
+				_ = c.Conn.SetReadDeadline(time.Time{})
+				return user, true
+			}
+			if err := s.sendTo(c.ID, "Login failed. "+protocol.UsernamePrompt); err != nil {
+				s.closeClient(c.ID, err)
+				return "", false
+			}
+			continue
+		}
+		name = truncateRunes(strings.TrimSpace(line), cfg.MaxNameRunes)
+		switch {
+		case name == "" && !cfg.AllowGuests && !cfg.AnimalNames:
+			_ = s.sendToWait(c.ID, "Username cannot be empty.\n")
+			s.closeClient(c.ID, errEmptyName)
+			return "", false
+		case name != "" && nameProblem(name, cfg.CommandPrefix) != "":
+			if err := s.sendTo(c.ID, nameProblem(name, cfg.CommandPrefix)+" "+protocol.UsernamePrompt); err != nil {
+				s.closeClient(c.ID, err)
+				return "", false
+			}
+			continue
+		case s.accounts.registered(name):
+			if err := s.sendTo(c.ID, name+" is a registered name; use "+cfg.CommandPrefix+"login "+name+" <password>. "+protocol.UsernamePrompt); err != nil {
+				s.closeClient(c.ID, err)
+				return "", false
+			}
+			continue
+		}
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		return name, true
+	}
+}
+
+func (s *Server) clientRoutine(clientID int) {
+	c := s.lookupClient(clientID)
+	if c == nil || c.Conn == nil {
+		s.closeClient(clientID, errClientGone)
+		return
+	}
+
+	if err := s.sendTo(clientID, protocol.UsernamePrompt); err != nil {
+		s.closeClient(clientID, err)
+		return
+	}
+	cfg := s.config()
+	// one deadline for the whole login, however the reads are split up
+	var deadline time.Time
+	if cfg.LoginTimeout > 0 {
+		deadline = time.Now().Add(cfg.LoginTimeout)
+	}
+	reader, err := s.detectFraming(c, deadline)
+	if err != nil {
+		s.loginReadFailed(c, err)
+		return
+	}
+	clientName, ok := s.awaitName(c, reader, deadline)
+	if !ok {
+		return
+	}
+
+	s.lockClients.Lock()
+	if clientName == "" && cfg.AnimalNames {
+		clientName = s.animalNameLocked()
+	} else if clientName == "" {
+		clientName = s.guestNameLocked(clientID)
+	}
+	c.Name = clientName
+	c.LastActive.Store(time.Now().UnixNano())
+	s.clientList = append(s.clientList, c)
+	token := s.issueTokenLocked(c)
+	fmt.Println(clientName)
+	s.lockClients.Unlock()
+
+	if err := s.sendTo(clientID, s.welcome(clientName)+s.tokenNotice(token)); err != nil {
+		s.closeClient(clientID, err)
+		return
+	}
+	if err := s.deliverOffline(clientID); err != nil {
+		s.closeClient(clientID, err)
+		return
+	}
+
+	// Logged in. Main recv loop: one message per line, or per run of
+	// continued lines for a client whose hello asked for them
+	s.lockClients.RLock()
+	read := readLine
+	if c.multiline {
+		read = readMessage
+	}
+	s.lockClients.RUnlock()
+	for {
+		temp, err := read(reader, s.frameLimit())
+		if err != nil {
+			s.logProtocolError(c, err)
+			s.closeClient(clientID, err)
+			return
+		}
+		s.countIn(c, temp)
+		if temp == "" {
+			continue
+		}
+		s.touch(c)
+
+		// only an exact command word counts; a doubled prefix ("//") escapes
+		// chat that starts with the prefix
+		// chat lines go through the middleware chain before broadcast
+		prefix := s.config().CommandPrefix
+		if name, args, ok := parseCommand(temp, prefix); ok && !strings.HasPrefix(temp, prefix+prefix) {
+			err = s.runCommand(clientID, name, strings.ReplaceAll(args, "\n", " "))
+		} else if msg, ok := s.filter(clientID, strings.TrimPrefix(temp, prefix)); ok {
+			s.Broadcast(clientID, msg)
+		}
+		if err != nil {
+			s.closeClient(clientID, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,856 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+const testTimeout = 2 * time.Second
+
+// testClient is the far end of a net.Pipe handed to Server.HandleConn.
+// A background goroutine keeps reading so server writes never block.
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	server  *serverConn
+	chunks  chan string
+	pending string
+}
+
+// serverConn is the server's end of the pipe; its writes can be made to fail
+// while reads keep working.
+type serverConn struct {
+	net.Conn
+	failWrites atomic.Bool
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	if c.failWrites.Load() {
+		return 0, errors.New("write failed")
+	}
+	return c.Conn.Write(b)
+}
+
+func newTestServer(t *testing.T) *Server {
+	return newTestServerWith(t, DefaultConfig())
+}
+
+func newTestServerWith(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	s := New(cfg)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// dial attaches a new pipe connection to s without logging in.
+func dial(t *testing.T, s *Server) *testClient {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	tc := &testClient{
+		t:      t,
+		conn:   clientSide,
+		server: &serverConn{Conn: serverSide},
+		chunks: make(chan string, 4096),
+	}
+	go func() {
+		defer close(tc.chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := clientSide.Read(buf)
+			if n > 0 {
+				tc.chunks <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go s.HandleConn(tc.server)
+	t.Cleanup(func() { _ = clientSide.Close() })
+	return tc
+}
+
+// connect dials s and logs in as name, discarding the welcome banner.
+func connect(t *testing.T, s *Server, name string) *testClient {
+	t.Helper()
+	tc := dial(t, s)
+	tc.expect("Please enter your username: ")
+	tc.send(name)
+	tc.expect("Welcome " + name)
+	tc.skip()
+	tc.pending = ""
+	return tc
+}
+
+// connectMultiline is connect for a client whose hello asks for lines
+// ending in a backslash to be continued, as the client's -multiline does.
+func connectMultiline(t *testing.T, s *Server, name string) *testClient {
+	t.Helper()
+	tc := dial(t, s)
+	tc.expect("Please enter your username: ")
+	tc.send(protocol.ClientHello + "1 test " + protocol.MultilineFeature)
+	tc.send(name)
+	tc.expect("Welcome " + name)
+	tc.skip()
+	tc.pending = ""
+	return tc
+}
+
+// breakWrites makes every server write to this client fail.
+func (tc *testClient) breakWrites() {
+	tc.server.failWrites.Store(true)
+}
+
+func (tc *testClient) send(line string) {
+	tc.t.Helper()
+	if _, err := tc.conn.Write([]byte(line + "\n")); err != nil {
+		tc.t.Fatalf("send %q: %v", line, err)
+	}
+}
+
+// expect waits until want shows up in the output and consumes everything
+// up to and including it.
+func (tc *testClient) expect(want string) {
+	tc.t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		if i := strings.Index(tc.pending, want); i >= 0 {
+			tc.pending = tc.pending[i+len(want):]
+			return
+		}
+		select {
+		case chunk, ok := <-tc.chunks:
+			if !ok {
+				tc.t.Fatalf("connection closed waiting for %q; got %q", want, tc.pending)
+			}
+			tc.pending += chunk
+		case <-deadline:
+			tc.t.Fatalf("timed out waiting for %q; got %q", want, tc.pending)
+		}
+	}
+}
+
+// expectQuiet fails if anything containing unwanted arrives shortly.
+func (tc *testClient) expectQuiet(unwanted string) {
+	tc.t.Helper()
+	tc.skip()
+	if strings.Contains(tc.pending, unwanted) {
+		tc.t.Fatalf("unexpected %q in %q", unwanted, tc.pending)
+	}
+	tc.pending = ""
+}
+
+// expectClosed waits for the server to hang up.
+func (tc *testClient) expectClosed() {
+	tc.t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case chunk, ok := <-tc.chunks:
+			if !ok {
+				return
+			}
+			tc.pending += chunk
+		case <-deadline:
+			tc.t.Fatalf("connection still open; got %q", tc.pending)
+		}
+	}
+}
+
+// skip collects output until the connection has been quiet for a moment.
+func (tc *testClient) skip() {
+	for {
+		select {
+		case chunk, ok := <-tc.chunks:
+			if !ok {
+				return
+			}
+			tc.pending += chunk
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestLoginTruncatesLongName(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxNameRunes = 3
+	s := newTestServerWith(t, cfg)
+
+	tc := dial(t, s)
+	tc.expect("username: ")
+	tc.send("żółwik")
+	tc.expect("Welcome żół!")
+}
+
+func TestLoginNameLengthBoundary(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxNameRunes = 5
+	s := newTestServerWith(t, cfg)
+
+	for _, tt := range []struct{ in, want string }{
+		{"abcd", "abcd"},
+		{"abcde", "abcde"},
+		{"abcdef", "abcde"},
+		{"  日本語の名前です  ", "日本語の名"},
+		{"abcde:", "abcde"}, // the colon is cut off before it is checked
+	} {
+		tc := dial(t, s)
+		tc.expect("username: ")
+		tc.send(tt.in)
+		tc.expect("Welcome " + tt.want + "!")
+	}
+}
+
+func TestLoginNameForbiddenCharacters(t *testing.T) {
+	s := newTestServer(t)
+	tc := dial(t, s)
+	tc.expect("username: ")
+	for _, tt := range []struct{ in, reply string }{
+		{"/alice", "Usernames can't start with /."},
+		{"al/ice", "Usernames can't contain /."},
+		{"alice:", "Usernames can't contain ':'."},
+		{"al:ice", "Usernames can't contain ':'."},
+		{"\x1b[31malice", "Usernames can't contain control characters."},
+		{"al\tice", "Usernames can't contain control characters."},
+	} {
+		tc.send(tt.in)
+		tc.expect(tt.reply + " " + protocol.UsernamePrompt)
+	}
+	tc.send("alice")
+	tc.expect("Welcome alice!")
+
+	bob := connect(t, s, "bob")
+	bob.send("/nick bo:b")
+	bob.expect("Usernames can't contain ':'.\n")
+	bob.send("/nick bo/b")
+	bob.expect("Usernames can't contain /.\n")
+}
+
+func TestBlankNameRejected(t *testing.T) {
+	s := newTestServer(t)
+	tc := dial(t, s)
+	tc.expect("username: ")
+	tc.send("   ")
+	tc.expect("Username cannot be empty.\n")
+	tc.expectClosed()
+}
+
+func TestMOTD(t *testing.T) {
+	s := newTestServer(t)
+	s.SetMOTD("No spam please.")
+	tc := dial(t, s)
+	tc.expect("username: ")
+	tc.send("alice")
+	tc.expect("Welcome alice! You can use the following commands:\n/users")
+	tc.expect("/help - Show this list (/h, /?)\nNo spam please.\n")
+
+	// reloading with an empty text goes back to the built-in banner
+	s.SetMOTD("")
+	tc = dial(t, s)
+	tc.expect("username: ")
+	tc.send("bob")
+	tc.expect("/help - Show this list (/h, /?)\n")
+	tc.expectQuiet("No spam")
+
+	cfg := DefaultConfig()
+	cfg.MOTDReplacesHelp = true
+	s = newTestServerWith(t, cfg)
+	s.SetMOTD("Read the rules at example.org\n")
+	tc = dial(t, s)
+	tc.expect("username: ")
+	tc.send("carol")
+	tc.expect("Welcome carol!\nRead the rules at example.org\n")
+	tc.expectQuiet("/users")
+}
+
+func TestReloadKeepsSessions(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	cfg := DefaultConfig()
+	cfg.MaxMessageRunes = 3
+	s.Reload(cfg)
+
+	alice.send("hello")
+	bob.expect("[Global] alice: hel\n")
+}
+
+func TestDisconnectNotice(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	carol := connect(t, s, "carol")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+	carol.send("/join rust")
+	carol.expect("Successfully joined group rust\n")
+
+	bob.send("/quit")
+	alice.expect("[rust] bob disconnected (quit)\n")
+	carol.conn.Close()
+	alice.expect("[rust] carol disconnected (connection closed")
+}
+
+// Run with -race: every goroutine closes bob at once, and only one of them
+// may tear him down.
+func TestConcurrentCloseClient(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.closeClient(2, errQueueFull)
+		}()
+	}
+	wg.Wait()
+
+	alice.expect("[rust] bob disconnected (too slow to keep up)\n")
+	alice.expectQuiet("disconnected")
+	s.lockClients.RLock()
+	defer s.lockClients.RUnlock()
+	if len(s.clientList) != 1 || s.idToClient[2] != nil || len(s.groupsToClient["rust"]) != 1 {
+		t.Fatalf("bob not cleanly removed: clients %d, rust %v", len(s.clientList), s.groupsToClient["rust"])
+	}
+}
+
+func TestConcurrentRegisterIDs(t *testing.T) {
+	s := newTestServer(t)
+	const workers, each = 20, 10
+	ids := make([][]int, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				conn, peer := net.Pipe()
+				t.Cleanup(func() { peer.Close() })
+				ids[w] = append(ids[w], s.register(conn).ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for _, got := range ids {
+		for i, id := range got {
+			if seen[id] {
+				t.Fatalf("ID %d handed out twice", id)
+			}
+			seen[id] = true
+			if i > 0 && id <= got[i-1] {
+				t.Fatalf("IDs went backwards within one goroutine: %v", got)
+			}
+		}
+	}
+	for id := 1; id <= workers*each; id++ {
+		if !seen[id] || s.idToClient[id] == nil {
+			t.Fatalf("ID %d missing", id)
+		}
+	}
+}
+
+func TestSmallReadBufferKeepsLinesWhole(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReadBuffer = 16 // raised to minReadBuffer
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	long := strings.Repeat("abcdefghij", 50)
+	alice.send(long)
+	bob.expect("[Global] alice: " + long + "\n")
+}
+
+func TestOversizedLineDropsClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxMessageRunes = 10
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	// over the cap, but a plausible message: truncated, not dropped
+	bob.send(strings.Repeat("y", 100))
+	alice.expect("[rust] bob: yyyyyyyyyy\n")
+
+	go bob.conn.Write([]byte(strings.Repeat("x", s.frameLimit()+1) + "\n"))
+	alice.expect("[rust] bob disconnected (sent an oversized line)\n")
+
+	carol := dial(t, s)
+	carol.expect(protocol.UsernamePrompt)
+	carol.send("carol\x00\x01")
+	carol.expectClosed()
+}
+
+func TestRawChunkClient(t *testing.T) {
+	s := newTestServer(t)
+	bob := connect(t, s, "bob")
+
+	// a legacy client that never sends newlines, one message per write
+	alice := dial(t, s)
+	alice.expect(protocol.UsernamePrompt)
+	if _, err := alice.conn.Write([]byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	alice.expect("Welcome alice!")
+	if _, err := alice.conn.Write([]byte("hello there")); err != nil {
+		t.Fatal(err)
+	}
+	bob.expect("[Global] alice: hello there\n")
+	if _, err := alice.conn.Write([]byte("/groups")); err != nil {
+		t.Fatal(err)
+	}
+	alice.expect("Available Groups:\n")
+
+	// a line client whose first line arrives in pieces stays line-based
+	carol := dial(t, s)
+	carol.expect(protocol.UsernamePrompt)
+	for _, part := range []string{"car", "ol\nhi\\\n", "again\n"} {
+		if _, err := carol.conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob.expect("[Global] carol: hi\\\n")
+	bob.expect("[Global] carol: again\n")
+}
+
+func TestDisconnectReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "closed"},
+		{errQuit, "quit"},
+		{io.EOF, "connection closed by client"},
+		{errQueueFull, "too slow to keep up"},
+		{errLineTooLong, "sent an oversized line"},
+		{errBinaryData, "sent binary data"},
+		{fmt.Errorf("write failed: %w", os.ErrDeadlineExceeded), "timed out"},
+		{errors.New("write failed: broken pipe"), "write failed: broken pipe"},
+	}
+	for _, tt := range tests {
+		if got := disconnectReason(tt.err); got != tt.want {
+			t.Errorf("disconnectReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestGuestNames(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowGuests = true
+	s := newTestServerWith(t, cfg)
+	squatter := connect(t, s, "Guest2")
+
+	guest := dial(t, s)
+	guest.expect("username: ")
+	guest.send("")
+	guest.expect("Welcome Guest2-2!")
+
+	squatter.send("/users")
+	squatter.expect("Connected Users:\n1. Guest2\n2. Guest2-2\n")
+}
+
+func TestWriteTimeoutDropsStalledClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WriteTimeout = 50 * time.Millisecond
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	// bob logs in by hand and then never reads again
+	bob, serverSide := net.Pipe()
+	defer bob.Close()
+	go s.HandleConn(serverSide)
+	buf := make([]byte, 4096)
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Write([]byte("bob\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Read(buf); err != nil { // welcome banner, one write
+		t.Fatal(err)
+	}
+	alice.send("/users")
+	alice.expect("2. bob\n")
+
+	alice.send("hello?")
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+func TestFullQueueDropsClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QueueSize = 1
+	cfg.WriteTimeout = 0
+	s := newTestServerWith(t, cfg)
+	alice := connect(t, s, "alice")
+
+	bob, serverSide := net.Pipe()
+	defer bob.Close()
+	go s.HandleConn(serverSide)
+	buf := make([]byte, 4096)
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Write([]byte("bob\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// first message blocks bob's writer, second fills the queue, third overflows
+	for i := 0; i < 3; i++ {
+		alice.send("spam")
+	}
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+// loginOver logs name in over a real connection returned by net.Dial.
+func loginOver(t *testing.T, conn net.Conn, name string) {
+	t.Helper()
+	r := bufio.NewReader(conn)
+	if prompt, err := r.ReadString(':'); err != nil || !strings.Contains(prompt, "username") {
+		t.Fatalf("prompt = %q, %v", prompt, err)
+	}
+	if _, err := conn.Write([]byte(name + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if welcome, err := r.ReadString('\n'); err != nil || !strings.Contains(welcome, "Welcome "+name+"!") {
+		t.Fatalf("welcome = %q, %v", welcome, err)
+	}
+}
+
+func TestServeIPv6Loopback(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	s := newTestServer(t)
+	go s.Serve(ln)
+
+	addr, err := protocol.NormalizeAddr(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	loginOver(t, conn, "v6user")
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	s := newTestServer(t)
+	go s.Serve(ln)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	loginOver(t, conn, "local")
+}
+
+func TestCloseDropsClients(t *testing.T) {
+	s := New(DefaultConfig())
+	tc := connect(t, s, "alice")
+	s.Close()
+	tc.expectClosed()
+	if !strings.Contains(tc.pending, "Server is shutting down.\n") {
+		t.Fatalf("no shutdown notice in %q", tc.pending)
+	}
+}
+
+func TestAnimalNames(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AnimalNames = true
+	s := newTestServerWith(t, cfg)
+
+	names := make(map[string]bool)
+	for range 3 {
+		guest := dial(t, s)
+		guest.expect("username: ")
+		guest.send("")
+		guest.expect("Welcome ")
+	}
+	s.lockClients.RLock()
+	for _, c := range s.clientList {
+		names[c.Name] = true
+	}
+	s.lockClients.RUnlock()
+	if len(names) != 3 {
+		t.Fatalf("guests share names: %v", names)
+	}
+	for name := range names {
+		if !slices.ContainsFunc(nameAdjectives, func(adj string) bool {
+			return strings.HasPrefix(name, adj) && slices.Contains(nameAnimals, strings.TrimPrefix(name, adj))
+		}) {
+			t.Errorf("unexpected guest name %q", name)
+		}
+	}
+}
+
+func TestUsernamePromptTimesOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LoginTimeout = 50 * time.Millisecond
+	s := newTestServerWith(t, cfg)
+
+	silent := dial(t, s)
+	silent.expect("username: ")
+	silent.expect("Username prompt timed out.\n")
+	silent.expectClosed()
+
+	// the deadline is lifted once the name arrives
+	alice := connect(t, s, "alice")
+	time.Sleep(100 * time.Millisecond)
+	alice.send("/users")
+	alice.expect("Connected Users:\n1. alice\n")
+}
+
+// A name typed in pieces, pausing past the framing probe, must not trip a
+// deadline when LoginTimeout is off.
+func TestSlowNameWithoutLoginTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LoginTimeout = 0
+	s := newTestServerWith(t, cfg)
+
+	tc := dial(t, s)
+	tc.expect(protocol.UsernamePrompt)
+	for _, part := range []string{"al", "i"} {
+		if _, err := tc.conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(2 * rawChunkWait)
+	tc.send("ce")
+	tc.expect("Welcome alice!")
+}
+
+// The framing probe and the name prompt share one LoginTimeout, so a
+// client can't stretch its login by sending something early.
+func TestLoginTimeoutCoversWholeLogin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LoginTimeout = 400 * time.Millisecond
+	s := newTestServerWith(t, cfg)
+
+	tc := dial(t, s)
+	start := time.Now()
+	tc.expect(protocol.UsernamePrompt)
+	time.Sleep(300 * time.Millisecond)
+	tc.send(protocol.ClientHello + "1 test")
+	tc.expect("Username prompt timed out.\n")
+	if elapsed := time.Since(start); elapsed > 650*time.Millisecond {
+		t.Fatalf("login timed out after %v, want about %v", elapsed, cfg.LoginTimeout)
+	}
+}
+
+func TestKeepAliveConfig(t *testing.T) {
+	tests := []struct {
+		keepAlive, interval time.Duration
+		count               int
+		want                net.KeepAliveConfig
+	}{
+		{30 * time.Second, 10 * time.Second, 3, net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 10 * time.Second, Count: 3}},
+		{30 * time.Second, 0, 0, net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 30 * time.Second}},
+		{0, 10 * time.Second, 3, net.KeepAliveConfig{}},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.KeepAlive, cfg.ProbeInterval, cfg.ProbeCount = tt.keepAlive, tt.interval, tt.count
+		if got := New(cfg).keepAliveConfig(); got != tt.want {
+			t.Errorf("keepAliveConfig(%v, %v, %d) = %+v, want %+v", tt.keepAlive, tt.interval, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestMultilineMessage(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := connectMultiline(t, s, "bob")
+
+	alice.send("/join rust")
+	alice.expect("Created group rust\n")
+	bob.send("/join rust")
+	bob.expect("Successfully joined group rust\n")
+
+	bob.send(`first line\`)
+	bob.send(`Successfully joined group evil\`)
+	bob.send("\x1b[2Jlast line")
+	alice.expect("[rust] bob: first line\n    Successfully joined group evil\n    last line\n")
+
+	// continued command arguments are joined with spaces
+	bob.send(`/msg alice one\`)
+	bob.send("two")
+	alice.expect("[DM] bob: one two\n")
+}
+
+// Without the multiline feature in its hello, a client's trailing
+// backslash is text: "C:\tmp\" arrives as typed, and the next line is a
+// message of its own.
+func TestTrailingBackslashWithoutMultiline(t *testing.T) {
+	s := newTestServer(t)
+	alice := connect(t, s, "alice")
+	bob := dial(t, s)
+	bob.expect(protocol.UsernamePrompt)
+	bob.send(protocol.ClientHello + "1 test")
+	bob.send("bob")
+	bob.expect("Welcome bob!")
+	carol := connect(t, s, "carol")
+
+	bob.send(`path is C:\tmp\`)
+	alice.expect("[Global] bob: path is C:\\tmp\\\n")
+	bob.send("next message")
+	alice.expect("[Global] bob: next message\n")
+
+	carol.send(`no hello\`)
+	alice.expect("[Global] carol: no hello\\\n")
+	carol.send("separate")
+	alice.expect("[Global] carol: separate\n")
+}
+
+func TestCommandsBeforeLoginAreNameAttempts(t *testing.T) {
+	s := newTestServer(t)
+	tc := dial(t, s)
+	tc.expect("Please enter your username: ")
+	tc.send("/users")
+	tc.expect("Usernames can't start with /. Please enter your username: ")
+	tc.send("/quit")
+	tc.expect("Usernames can't start with /. Please enter your username: ")
+	tc.expectQuiet("Connected Users")
+	tc.send("alice")
+	tc.expect("Welcome alice!")
+	tc.send("/users")
+	tc.expect("Connected Users:\n")
+}
+
+func TestServeAllListeners(t *testing.T) {
+	s := New(DefaultConfig())
+	var lns []net.Listener
+	for range 2 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.ServeAll(lns...) }()
+
+	for i, ln := range lns {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		loginOver(t, conn, fmt.Sprintf("user%d", i))
+	}
+
+	s.Close()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), lns[0].Addr().String()) || !strings.Contains(err.Error(), lns[1].Addr().String()) {
+			t.Fatalf("ServeAll = %v, want an error per listener", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("ServeAll still running after Close")
+	}
+	if err := s.Serve(lns[0]); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Serve after Close = %v", err)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	s := New(DefaultConfig())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	loginOver(t, conn, "alice")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run after cancel = %v, want nil", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Run still running after cancel")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	rest, _ := io.ReadAll(conn)
+	if !strings.Contains(string(rest), "Server is shutting down.\n") {
+		t.Fatalf("client got %q, want the shutdown notice", rest)
+	}
+}
+
+func TestShutdownWaitsForConnections(t *testing.T) {
+	s := New(DefaultConfig())
+	alice := connect(t, s, "alice")
+	bob := connect(t, s, "bob")
+
+	if !s.Shutdown(testTimeout) {
+		t.Fatal("Shutdown timed out with only idle clients")
+	}
+	alice.expect("Server is shutting down.\n")
+	bob.expect("Server is shutting down.\n")
+
+	// a connection arriving after shutdown is turned away at once
+	late := dial(t, s)
+	late.expectClosed()
+
+	s.conns.Add(1) // a goroutine that never finishes
+	defer s.conns.Done()
+	if s.Shutdown(50 * time.Millisecond) {
+		t.Fatal("Shutdown reported success with a connection still running")
+	}
+}
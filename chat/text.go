@@ -0,0 +1,142 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// upper bound on a single line; protects against peers that never send '\n'
+const maxLineBytes = 64 * 1024
+
+// room a client line needs beyond the message itself: a command word and
+// arguments such as the /msg recipient
+const frameOverhead = 1024
+
+// Protocol errors: a line no -maxmsg setting allows, or bytes that are not
+// text at all. Either means the peer isn't speaking the chat protocol.
+var (
+	errLineTooLong = errors.New("line too long")
+	errBinaryData  = errors.New("binary data")
+)
+
+// remove int from slice, preserving order
+func removeIntFromSlice(a []int, x int) []int {
+	out := a[:0]
+	for _, v := range a {
+		if v != x {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// truncateRunes cuts s to at most max runes, always on a rune boundary
+func truncateRunes(s string, max int) string {
+	n := 0
+	for i := range s {
+		if n == max {
+			return s[:i]
+		}
+		n++
+	}
+	return s
+}
+
+// readLine reads one '\n'-terminated message and strips the line ending.
+// Messages may span several TCP reads; they are accumulated until the
+// delimiter, but never past limit bytes, and a NUL byte ends the read
+// early: text clients never send one.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if bytes.IndexByte(chunk, 0) >= 0 {
+			return "", errBinaryData
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return "", errLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// readMessage reads one message: a line, plus the next one as long as the
+// line is protocol.Continued. The markers are dropped, doubled trailing
+// backslashes are halved, and the lines kept apart by '\n'. The whole
+// message is bounded by limit.
+func readMessage(r *bufio.Reader, limit int) (string, error) {
+	var b strings.Builder
+	for {
+		line, err := readLine(r, limit)
+		if err != nil {
+			return "", err
+		}
+		more := protocol.Continued(line)
+		body := strings.TrimRight(line, `\`)
+		b.WriteString(body + strings.Repeat(`\`, (len(line)-len(body))/2))
+		if b.Len() > limit {
+			return "", errLineTooLong
+		}
+		if !more {
+			return b.String(), nil
+		}
+		b.WriteByte('\n')
+	}
+}
+
+// frameMessage is the inverse of readMessage: it doubles each line's
+// trailing backslashes and marks every line but the last as continued.
+func frameMessage(msg string) string {
+	lines := strings.Split(msg, "\n")
+	for i, l := range lines {
+		body := strings.TrimRight(l, `\`)
+		lines[i] = l + l[len(body):]
+	}
+	return strings.Join(lines, "\\\n")
+}
+
+// indentContinuation indents the second and later lines of a multi-line
+// message so they read as part of it and can't pass for server output.
+func indentContinuation(text string) string {
+	return strings.ReplaceAll(text, "\n", "\n    ")
+}
+
+// mentionedNames returns the set of names written as @name in text.
+// Trailing punctuation is ignored, so "@bob," mentions bob.
+func mentionedNames(text string) map[string]bool {
+	names := make(map[string]bool)
+	for _, word := range strings.Fields(text) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		name := strings.TrimRight(word[1:], ".,!?:;)")
+		if name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// sortNames orders names case-insensitively, breaking ties byte-wise so the
+// result is fully deterministic.
+func sortNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		a, b := strings.ToLower(names[i]), strings.ToLower(names[j])
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 2, "he"},
+		{"héllo", 2, "hé"},
+		{"日本語", 1, "日"},
+		{"", 3, ""},
+		{"abc", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateRunes(tt.in, tt.max); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	r := bufio.NewReaderSize(strings.NewReader("one\r\n"+long+"\ntwo"), 16)
+
+	for _, want := range []string{"one", long} {
+		got, err := readLine(r, maxLineBytes)
+		if err != nil || got != want {
+			t.Fatalf("readLine = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := readLine(r, maxLineBytes); err == nil {
+		t.Fatal("expected error for unterminated line")
+	}
+}
+
+func TestReadLineTooLong(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(strings.Repeat("x", maxLineBytes+1) + "\n"))
+	if _, err := readLine(r, maxLineBytes); err != errLineTooLong {
+		t.Fatalf("err = %v, want errLineTooLong", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("short\n" + strings.Repeat("x", 101) + "\n"))
+	if got, err := readLine(r, 100); got != "short" || err != nil {
+		t.Fatalf("readLine = %q, %v; want %q", got, err, "short")
+	}
+	if _, err := readLine(r, 100); err != errLineTooLong {
+		t.Fatalf("over the limit: err = %v, want errLineTooLong", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("\x00\x01\xff\xfe garbage\n"))
+	if _, err := readLine(r, maxLineBytes); err != errBinaryData {
+		t.Fatalf("NUL byte: err = %v, want errBinaryData", err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("one\\\r\ntwo\\\nthree\nfour\n"))
+	for _, want := range []string{"one\ntwo\nthree", "four"} {
+		got, err := readMessage(r, maxLineBytes)
+		if err != nil || got != want {
+			t.Fatalf("readMessage = %q, %v; want %q", got, err, want)
+		}
+	}
+	if got := frameMessage("one\ntwo"); got != "one\\\ntwo" {
+		t.Fatalf("frameMessage = %q", got)
+	}
+
+	// doubled trailing backslashes are literal, and survive a round trip
+	for _, msg := range []string{`C:\tmp\`, "a\\\nb\\", `ends in two\\`} {
+		r = bufio.NewReader(strings.NewReader(frameMessage(msg) + "\nnext\n"))
+		if got, err := readMessage(r, maxLineBytes); err != nil || got != msg {
+			t.Fatalf("readMessage(frameMessage(%q)) = %q, %v", msg, got, err)
+		}
+		if got, _ := readMessage(r, maxLineBytes); got != "next" {
+			t.Fatalf("after %q: readMessage = %q, want %q", msg, got, "next")
+		}
+	}
+
+	half := strings.Repeat("x", maxLineBytes/2+1)
+	r = bufio.NewReader(strings.NewReader(half + "\\\n" + half + "\n"))
+	if _, err := readMessage(r, maxLineBytes); err != errLineTooLong {
+		t.Fatalf("err = %v, want errLineTooLong", err)
+	}
+}
+
+func TestMentionedNames(t *testing.T) {
+	got := mentionedNames("hey @bob, @carol! mail me@example.com or @ alone @bob")
+	want := map[string]bool{"bob": true, "carol": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("mentionedNames = %v, want %v", got, want)
+	}
+}
+
+func TestExpandShortcodes(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{"hi :wave:", "hi 👋"},
+		{":+1::fire:", "👍🔥"},
+		{"at 10:30 :tada:", "at 10:30 🎉"},
+		{":nope: :smile:", ":nope: 😄"},
+		{"日本:heart:語", "日本❤️語"},
+		{"half :smile", "half :smile"},
+		{"::", "::"},
+	}
+	for _, tt := range tests {
+		if got := expandShortcodes(tt.in); got != tt.want {
+			t.Errorf("expandShortcodes(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Reading a stream of typical chat lines plus the odd long one through
+// readers of different sizes. Lines longer than the buffer still arrive
+// whole; they just take several ReadSlice calls.
+func BenchmarkReadMessage(b *testing.B) {
+	var stream strings.Builder
+	for i := range 1000 {
+		if i%100 == 0 {
+			stream.WriteString(strings.Repeat("x", 8000) + "\n")
+			continue
+		}
+		stream.WriteString("[rust] alice: has anyone tried the new borrow checker errors?\n")
+	}
+	data := stream.String()
+	for _, size := range []int{512, 1024, 4096, 16384, 65536} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				r := bufio.NewReaderSize(strings.NewReader(data), size)
+				for {
+					if _, err := readMessage(r, maxLineBytes); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// Version is the server build, set at link time:
+//
+//	go build -ldflags "-X chat-app-go/chat.Version=v1.4.0" ./server
+var Version = "dev"
+
+// maxVersionRunes caps the version string a client can report.
+const maxVersionRunes = 32
+
+// acceptClientHello records the build and features reported by a client's
+// hello line, logs it, and warns a client whose protocol is older than the
+// server's.
+func (s *Server) acceptClientHello(c *Client, line string) error {
+	protoStr, rest, _ := strings.Cut(strings.TrimPrefix(line, protocol.ClientHello), " ")
+	proto, err := strconv.Atoi(protoStr)
+	if err != nil {
+		return nil // not a hello we understand; ignore it
+	}
+	version, features, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	version = truncateRunes(protocol.StripControl(version), maxVersionRunes)
+	if version == "" {
+		version = "unknown"
+	}
+
+	s.lockClients.Lock()
+	c.ClientVersion, c.clientProto = version, proto
+	c.multiline = slices.Contains(strings.Fields(features), protocol.MultilineFeature)
+	s.lockClients.Unlock()
+
+	fmt.Printf("client at %s is version %s (protocol %d)\n", c.Addr, version, proto)
+	if proto < protocol.Version {
+		return s.sendTo(c.ID, fmt.Sprintf("Your client speaks protocol %d, but this server speaks %d; some features may not work until you upgrade.\n", proto, protocol.Version))
+	}
+	return nil
+}
+
+// version handles "/version": the server build, and the client's if it
+// reported one.
+func (s *Server) version(clientID int, _ string) error {
+	out := fmt.Sprintf("Server version: %s (protocol %d)\n", Version, protocol.Version)
+	s.lockClients.RLock()
+	if c := s.idToClient[clientID]; c != nil && c.ClientVersion != "" {
+		out += fmt.Sprintf("Your client: %s (protocol %d)\n", c.ClientVersion, c.clientProto)
+	}
+	s.lockClients.RUnlock()
+	return s.sendTo(clientID, out)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"compress/flate"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,93 +11,538 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// default for -addr; an address without a port gets protocol.DefaultPort
+const defaultServer = "13.200.235.191:8080"
+
+// reconnect backoff: doubles after each failed attempt up to the cap
+const (
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
 )
 
+// how long to wait for the server to hang up after /quit
+const quitTimeout = 2 * time.Second
+
+// version is the client build, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.4.0" ./client
+var version = "dev"
+
+// most lines held for resending after a reconnect; older ones are dropped
+const maxUnsent = 100
+
 var (
-	appendNewline bool
-	conn          net.Conn
+	appendNewline  bool
+	keepAlive      time.Duration
+	connectTimeout time.Duration
+	reconnect      bool
+	compress       bool
+	trustServer    bool          // print server output unfiltered
+	inputPath      string        // read lines from this file instead of stdin
+	inputDelay     time.Duration // pause between lines read from inputPath
+	stay           bool          // keep the session open once inputPath is exhausted
+	serverAddr     string
+	unixPath       string        // dial this Unix socket instead of serverAddr
+	cmdPrefix      string        // the server's command prefix, for the commands the client sends or handles itself
+	multiline      bool          // a line ending in a backslash continues the message on the next line
+	loginName      string        // -name: log in as this user without reading it from the input
+	themeSpec      string        // -theme: built-in theme name or theme file
+	quiet          bool          // -quiet: no connection status or error chatter
+	raw            bool          // -raw: server bytes go to stdout untouched, with no prompt, colors or cursor control
+	idleAway       time.Duration // -idle-away: send /away idle after this long without input; 0 disables
+
+	connMu sync.Mutex
+	conn   net.Conn // current connection; swapped on reconnect
+
+	unsent []string // lines whose write failed, sent again after the next login; only session touches it
 )
 
-func handleSigint() {
+func setConn(c net.Conn) {
+	connMu.Lock()
+	conn = c
+	connMu.Unlock()
+}
+
+func closeConn() {
+	connMu.Lock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+	connMu.Unlock()
+}
+
+// chatter reports connection status and recoverable errors on stderr,
+// unless -quiet is set.
+func chatter(a ...any) {
+	if !quiet {
+		fmt.Fprintln(os.Stderr, a...)
+	}
+}
+
+// handleSignals closes the connection cleanly on Ctrl-C or SIGTERM.
+func handleSignals() {
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-ch
-		fmt.Println("detected exit")
-		if conn != nil {
-			_ = conn.Close()
-		}
+		chatter("detected exit")
+		closeConn()
+		teardownStatus()
 		os.Exit(0)
 	}()
 }
 
-func main() {
-	flag.BoolVar(&appendNewline, "append-newline", false, "append a newline when sending (use if server expects line-based input)")
-	flag.Parse()
+func dial() (net.Conn, error) {
+	network, addr := "tcp", serverAddr
+	if unixPath != "" {
+		network, addr = "unix", unixPath
+	}
+	c, err := net.DialTimeout(network, addr, connectTimeout)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, fmt.Errorf("no answer from %s within %s", addr, connectTimeout)
+		}
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetKeepAlive(keepAlive > 0)
+		if keepAlive > 0 {
+			_ = tc.SetKeepAlivePeriod(keepAlive)
+		}
+	}
+	if compress {
+		cc, err := negotiateCompression(c)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c = cc
+	}
+	return c, nil
+}
+
+// readInput sends each line read from r (without its line ending) on lines,
+// waiting delay after each one, and closes lines when r ends unless stay is
+// set, in which case the session keeps running until the server hangs up or
+// the user interrupts. It outlives individual connections, so lines read
+// while reconnecting wait for the next one.
+func readInput(r io.Reader, lines chan<- string, delay time.Duration, stay bool) {
+	if !stay {
+		defer close(lines)
+	}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n') // blocks until Enter
+		if line == "" && err != nil {
+			return
+		}
+		// C++ getline strips newline; replicate that
+		lines <- strings.TrimRight(line, "\r\n")
+		if err != nil {
+			return
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// holdUnsent keeps a line that didn't make it out for the next connection,
+// dropping the oldest held line once maxUnsent are waiting.
+func holdUnsent(line string) {
+	if len(unsent) == maxUnsent {
+		unsent = unsent[1:]
+		chatter("too many unsent lines; dropped the oldest")
+	}
+	unsent = append(unsent, line)
+}
 
-	handleSigint()
+func send(c net.Conn, line string) error {
+	if appendNewline {
+		line += "\n"
+	}
+	_, err := c.Write([]byte(line))
+	return err
+}
 
-	var err error
-	// conn, err = net.Dial("tcp", "0.0.0.0:8081")
-	conn, err = net.Dial("tcp", "13.200.235.191:8080")
-	if err != nil {
-		fmt.Println("connect:", err)
-		return
+// describeDisconnect turns the error that ended a read from the server into
+// a reason for the user, and says whether -reconnect should try again.
+// Network trouble (a reset, a timeout, the server going away) is worth
+// retrying; an admin's kick or a corrupted compressed stream is not. An
+// empty reason means the client closed the connection itself.
+func describeDisconnect(err error, kicked bool) (reason string, retry bool) {
+	var ne net.Error
+	var corrupt flate.CorruptInputError
+	switch {
+	case kicked:
+		return "an admin removed you", false
+	case err == nil, errors.Is(err, io.EOF):
+		return "the server closed the connection", true
+	case errors.Is(err, net.ErrClosed):
+		return "", true
+	case errors.Is(err, syscall.ECONNRESET):
+		return "the connection was reset", true
+	case errors.As(err, &ne) && ne.Timeout():
+		return "the connection timed out", true
+	case errors.As(err, &corrupt):
+		return "the server sent a corrupt compressed stream", false
+	}
+	return "connection lost: " + err.Error(), true
+}
+
+// splitLines cuts data into its complete lines, newlines included, and
+// the unfinished remainder, which the caller prepends to the next read.
+func splitLines(data string) (lines []string, rest string) {
+	for {
+		i := strings.IndexByte(data, '\n')
+		if i < 0 {
+			return lines, data
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+}
+
+// how long an unfinished line from the server is held for the rest of it
+const partialWait = 100 * time.Millisecond
+
+// lineBuffer reassembles server output split across reads into whole lines
+// before passing it to emit, so the ignore list, group tracking and colors
+// always see a line from its start. A line still unfinished after
+// partialWait is passed on as it is: the username prompt has no newline,
+// and neither may a legacy server's output.
+type lineBuffer struct {
+	emit func(string)
+
+	mu      sync.Mutex
+	partial string
+	timer   *time.Timer
+}
+
+// write adds data from one read, emits every line it completes and returns
+// them.
+func (lb *lineBuffer) write(data string) []string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lines, rest := splitLines(lb.partial + data)
+	lb.partial = rest
+	if len(lines) > 0 {
+		lb.emit(strings.Join(lines, ""))
+	}
+	if lb.timer != nil {
+		lb.timer.Stop()
+	}
+	if rest != "" {
+		lb.timer = time.AfterFunc(partialWait, lb.flush)
+	}
+	return lines
+}
+
+// flush emits whatever unfinished line is waiting.
+func (lb *lineBuffer) flush() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.timer != nil {
+		lb.timer.Stop()
+	}
+	if lb.partial != "" {
+		lb.emit(lb.partial)
+		lb.partial = ""
+	}
+}
+
+// isKickNotice reports whether a complete line from the server is the
+// notice an admin's disconnect sends. Only a line that starts with the
+// whole protocol.KickNotice counts, so neither a user quoting it in chat or
+// a DM nor chat in a group that happens to be named "kicked" (on a server
+// that allows one) can stop anyone's -reconnect.
+func isKickNotice(line string) bool {
+	return strings.HasPrefix(line, protocol.KickNotice)
+}
+
+// session relays input lines to c and c to stdout until the server goes
+// away. The first line sent on a fresh login is remembered in *username so a
+// reconnect can log in again. With -multiline, lines ending in a backslash
+// are held until the line that ends the message, and the block goes out in
+// one write; the server joins them back up. If login is set, its lines (the
+// username, then anything to send right after it) go out as soon as the
+// server asks for the username, and input waits until then, followed by any
+// line whose write failed on the previous connection. With -idle-away, a
+// spell without input sends "/away idle" and the next line sends "/back"
+// before it. It reports whether the input has ended and, if the server side
+// ended first, whether reconnecting makes sense.
+func session(c net.Conn, lines <-chan string, username *string, login []string) (stdinClosed, retry bool) {
+	var block []string // -multiline: continued lines not sent yet
+	input := lines
+	var idle <-chan time.Time // fires after idleAway without input
+	var idleTimer *time.Timer
+	autoAway := false // we sent /away idle and owe the server a /back
+	if idleAway > 0 {
+		idleTimer = time.NewTimer(idleAway)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	prompted := make(chan struct{})
+	if len(login) > 0 {
+		input = nil
 	}
-	fmt.Println("connected to server")
 
 	// --- Goroutine: read from server like C++ recv() ---
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
 		buf := make([]byte, 1024)
+		seen := "" // output so far while waiting for the prompt, which may arrive split
+		waiting := len(login) > 0
+		kicked := false
+		lb := &lineBuffer{emit: queueIncoming}
+		defer lb.flush()
 		for {
-			n, err := conn.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					fmt.Fprintln(os.Stderr, "connection disconnected")
-				} else {
-					fmt.Fprintln(os.Stderr, "receive:", err)
+			n, err := c.Read(buf)
+			if err != nil || n == 0 {
+				var reason string
+				reason, retry = describeDisconnect(err, kicked)
+				if reason != "" {
+					chatter("disconnected:", reason)
 				}
 				return
 			}
-			if n == 0 {
-				fmt.Fprintln(os.Stderr, "connection disconnected")
-				return
+			for _, line := range lb.write(string(buf[:n])) {
+				kicked = kicked || isKickNotice(line)
+			}
+			if waiting {
+				seen += string(buf[:n])
+				if strings.Contains(seen, protocol.UsernamePrompt) {
+					close(prompted)
+					waiting = false
+				} else if len(seen) > len(protocol.UsernamePrompt) {
+					seen = seen[len(seen)-len(protocol.UsernamePrompt):]
+				}
 			}
-			// Clear current line, print server data as-is
-			fmt.Print("\x1b[2K\r")
-			os.Stdout.Write(buf[:n])
-			// optional newline echo is up to server; we don't force here
 		}
 	}()
 
-	// --- Goroutine: read stdin and send like C++ (no newline by default) ---
-	go func() {
-		reader := bufio.NewReader(os.Stdin)
-		for {
-			line, err := reader.ReadString('\n') // blocks until Enter
-			if err != nil {
-				// stdin closed; close socket and exit sender
-				_ = conn.Close()
-				return
+	for {
+		select {
+		case <-done:
+			if reconnect && len(block) > 0 {
+				// still marked as continued, so the server joins them with
+				// whatever is typed after the reconnect
+				holdUnsent(strings.Join(block, "\n"))
+			}
+			return false, retry
+		case <-prompted:
+			for _, line := range login {
+				if err := send(c, line); err != nil {
+					chatter("send:", err)
+					break
+				}
+			}
+			for len(unsent) > 0 {
+				if err := send(c, stampPing(unsent[0])); err != nil {
+					chatter("send:", err)
+					break
+				}
+				unsent = unsent[1:]
 			}
-			// C++ getline strips newline; replicate that
-			line = strings.TrimRight(line, "\r\n")
-			if appendNewline {
-				line += "\n"
+			showPrompt()
+			prompted, input = nil, lines
+		case <-idle:
+			if *username != "" && !autoAway {
+				if err := send(c, cmdPrefix+"away idle"); err != nil {
+					chatter("send:", err)
+				} else {
+					autoAway = true
+				}
+			}
+		case line, ok := <-input:
+			if !ok {
+				// stdin closed: leave through /quit so the server runs its clean
+				// departure path, then give it a moment to hang up
+				if send(c, cmdPrefix+"quit") == nil {
+					select {
+					case <-done:
+					case <-time.After(quitTimeout):
+					}
+				}
+				_ = c.Close()
+				<-done
+				return true, false
+			}
+			if idleTimer != nil {
+				idleTimer.Reset(idleAway)
+			}
+			if autoAway {
+				// typing a /back or /away of your own settles it either way
+				if cmd, _, _ := strings.Cut(line, " "); cmd != cmdPrefix+"back" && cmd != cmdPrefix+"away" {
+					if err := send(c, cmdPrefix+"back"); err != nil {
+						chatter("send:", err)
+					}
+				}
+				autoAway = false
+			}
+			if multiline && *username != "" && protocol.Continued(line) {
+				block = append(block, line)
+				setComposing(true)
+				showPrompt()
+				continue
 			}
-			if _, err := conn.Write([]byte(line)); err != nil {
-				fmt.Fprintln(os.Stderr, "send:", err)
-				_ = conn.Close()
+			if len(block) > 0 {
+				line = strings.Join(append(block, line), "\n")
+				block = nil
+				setComposing(false)
+			}
+			if *username != "" && handleClearCommand(line) {
+				continue
+			}
+			if *username != "" && handleIgnoreCommand(line) {
+				showPrompt()
+				continue
+			}
+			isName := *username == ""
+			if isName {
+				*username = line
+			}
+			if err := send(c, stampPing(line)); err != nil {
+				chatter("send:", err)
+				if reconnect && !isName {
+					// the reconnect logs in with the name; anything else goes again after it
+					holdUnsent(line)
+				}
+				_ = c.Close()
+				<-done
+				return false, retry
+			}
+			showPrompt()
+		}
+	}
+}
+
+func main() {
+	flag.BoolVar(&appendNewline, "append-newline", true, "append a newline when sending (disable for legacy servers that read raw chunks)")
+	flag.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "give up on a connection attempt after this long (0 waits for the OS)")
+	flag.DurationVar(&keepAlive, "keepalive", 30*time.Second, "TCP keepalive period (0 disables)")
+	flag.BoolVar(&reconnect, "reconnect", false, "redial with backoff when the connection drops, logging in again with the same username and resuming the session")
+	flag.BoolVar(&trustServer, "trust-server", false, "print server output as-is instead of stripping escape sequences and control characters")
+	flag.BoolVar(&statusLine, "status", false, "keep a status line at the bottom of the terminal with your group and, if the server sends rosters, its member count")
+	flag.BoolVar(&compress, "compress", false, "deflate the stream if the server was also started with -compress")
+	flag.StringVar(&serverAddr, "addr", defaultServer, "server address: host:port, [ipv6]:port, a bare host (port "+protocol.DefaultPort+") or a bare port on this machine")
+	flag.StringVar(&unixPath, "unix", "", "connect to the server's Unix socket at this path instead of -addr")
+	flag.BoolVar(&multiline, "multiline", false, "end a line with a backslash to continue the message on the next line, and send the lines as one message")
+	flag.StringVar(&cmdPrefix, "prefix", "/", "command prefix the server was started with (-prefix)")
+	flag.StringVar(&loginName, "name", "", "log in as this username once the server asks for it, instead of taking the first input line")
+	flag.StringVar(&inputPath, "input", "", "read lines (username first, unless -name is given) from this file instead of stdin, for bots and scripted tests")
+	flag.DurationVar(&inputDelay, "input-delay", 500*time.Millisecond, "pause between lines sent from -input")
+	flag.BoolVar(&stay, "stay", false, "with -input, stay connected and keep printing messages after the last line instead of sending /quit")
+	flag.StringVar(&themeSpec, "theme", "", "color scheme: dark, light, mono, or a file of \"role = SGR code\" lines (roles: "+strings.Join(themeRoles, ", ")+"); without it only announcements are highlighted")
+	flag.BoolVar(&quiet, "quiet", false, "don't print connection status (connected, disconnected, reconnecting) or recoverable errors; for scripts")
+	flag.DurationVar(&idleAway, "idle-away", 0, "after this long without typing, mark yourself away with /away idle, and /back once you type again (0 disables)")
+	flag.BoolVar(&raw, "raw", false, "write server output to stdout exactly as received, with no prompt, colors, status line or line clearing; for logs and pipes")
+	showVersion := flag.Bool("version", false, "print the client version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("client", version)
+		return
+	}
+
+	t, err := loadTheme(themeSpec)
+	if err != nil {
+		fmt.Println("theme:", err)
+		return
+	}
+	if isTerminal(os.Stdout) && !raw && !noColor {
+		activeTheme = t
+	}
+
+	addr, err := protocol.NormalizeAddr(serverAddr)
+	if err != nil {
+		fmt.Println("connect:", err)
+		return
+	}
+	serverAddr = addr
+
+	handleSignals()
+	setupStatus()
+	defer teardownStatus()
+	startPrinter()
+	defer stopPrinter()
+
+	lines := make(chan string)
+	if inputPath != "" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			fmt.Println("input:", err)
+			return
+		}
+		defer f.Close()
+		go readInput(f, lines, inputDelay, stay)
+	} else {
+		go readInput(os.Stdin, lines, 0, false)
+	}
+
+	username := ""
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		c, err := dial()
+		if err != nil {
+			if !reconnect {
+				fmt.Println("connect:", err)
 				return
 			}
+			chatter(fmt.Sprintf("connect: %v; retrying in %s (attempt %d)", err, backoff, attempt))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxBackoff)
+			continue
 		}
-	}()
+		setConn(c)
+		if appendNewline {
+			// a failed write shows up as soon as the session reads
+			hello := fmt.Sprintf("%s%d %s", protocol.ClientHello, protocol.Version, version)
+			if multiline {
+				hello += " " + protocol.MultilineFeature
+			}
+			_, _ = fmt.Fprintln(c, hello)
+		}
+		displayMu.Lock()
+		token := sessionToken
+		displayMu.Unlock()
+		resetDisplay()
+		attempt, backoff = 0, initialBackoff
 
-	<-done // wait for server reader to finish
-	if conn != nil {
-		_ = conn.Close()
+		var login []string
+		switch {
+		case username != "":
+			chatter("reconnected to server, logging in as", username)
+			login = []string{username}
+			if token != "" {
+				// back into the group we were in, if the server still has it
+				login = append(login, cmdPrefix+"resume "+token)
+			}
+		case loginName != "":
+			chatter("connected to server, logging in as", loginName)
+			username = loginName
+			login = []string{loginName}
+		default:
+			chatter("connected to server")
+		}
+
+		stdinClosed, retry := session(c, lines, &username, login)
+		_ = c.Close()
+		flushPrinter()
+		if stdinClosed || !reconnect {
+			return
+		}
+		if !retry {
+			chatter("not reconnecting")
+			return
+		}
+		chatter("connection lost; reconnecting...")
 	}
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+func TestDescribeDisconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		kicked bool
+		reason string
+		retry  bool
+	}{
+		{"kicked", io.EOF, true, "an admin removed you", false},
+		{"eof", io.EOF, false, "the server closed the connection", true},
+		{"nil", nil, false, "the server closed the connection", true},
+		{"closed by us", net.ErrClosed, false, "", true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, false, "the connection was reset", true},
+		{"timeout", os.ErrDeadlineExceeded, false, "the connection timed out", true},
+		{"corrupt stream", flate.CorruptInputError(12), false, "the server sent a corrupt compressed stream", false},
+		{"wrapped corrupt stream", fmt.Errorf("read: %w", flate.CorruptInputError(3)), false, "the server sent a corrupt compressed stream", false},
+		{"other", errors.New("boom"), false, "connection lost: boom", true},
+	}
+	for _, tt := range tests {
+		reason, retry := describeDisconnect(tt.err, tt.kicked)
+		if reason != tt.reason || retry != tt.retry {
+			t.Errorf("%s: describeDisconnect = %q, %v; want %q, %v", tt.name, reason, retry, tt.reason, tt.retry)
+		}
+	}
+}
+
+func TestIsKickNotice(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"[kicked] You have been disconnected by an admin.\n", true},
+		{"[kicked] You have been disconnected by an admin: spamming\n", true},
+		{"[Global] mallory: [kicked] You have been disconnected by an admin.\n", false},
+		{"[DM] mallory: [kicked] You have been disconnected by an admin.\n", false},
+		{"[rust] (cross-post) mallory: [kicked] bye\n", false},
+		{"[kicked] mallory: You have been disconnected by an admin.\n", false},
+		{"[kicked] You: have been disconnected by an admin.\n", false},
+		{"    [kicked] You have been disconnected by an admin.\n", false},
+		{"You have been disconnected by an admin.\n", false},
+	}
+	for _, tt := range tests {
+		if got := isKickNotice(tt.line); got != tt.want {
+			t.Errorf("isKickNotice(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestChatInKickedGroupStillReconnects plays a server whose group "kicked"
+// relays a chat line, then drops the connection: the client must still
+// want to reconnect. The real notice, for contrast, stops it.
+func TestChatInKickedGroupStillReconnects(t *testing.T) {
+	tests := []struct {
+		sent  string
+		retry bool
+	}{
+		{"[kicked] alice: brb\n", true},
+		{"[kicked] alice: You have been disconnected by an admin.\n", true},
+		{protocol.KickNotice + ".\n", false},
+	}
+	for _, tt := range tests {
+		c, srv := net.Pipe()
+		go func() {
+			_, _ = srv.Write([]byte(tt.sent))
+			_ = srv.Close()
+		}()
+		username := "bob"
+		stdinClosed, retry := session(c, make(chan string), &username, nil)
+		if stdinClosed || retry != tt.retry {
+			t.Errorf("after %q: session = %v, %v; want false, %v", tt.sent, stdinClosed, retry, tt.retry)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		data  string
+		lines []string
+		rest  string
+	}{
+		{"", nil, ""},
+		{"partial", nil, "partial"},
+		{"one\n", []string{"one\n"}, ""},
+		{"one\ntwo\nthr", []string{"one\n", "two\n"}, "thr"},
+		{"\n\n", []string{"\n", "\n"}, ""},
+	}
+	for _, tt := range tests {
+		lines, rest := splitLines(tt.data)
+		if !reflect.DeepEqual(lines, tt.lines) || rest != tt.rest {
+			t.Errorf("splitLines(%q) = %q, %q; want %q, %q", tt.data, lines, rest, tt.lines, tt.rest)
+		}
+	}
+
+	// a notice split across two reads is still recognised once whole
+	_, rest := splitLines("[kic")
+	lines, _ := splitLines(rest + "ked] You have been disconnected by an admin.\n")
+	if len(lines) != 1 || !isKickNotice(lines[0]) {
+		t.Fatalf("split notice not recognised: %q", lines)
+	}
+}
+
+func TestLineBuffer(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	lb := &lineBuffer{emit: func(s string) {
+		mu.Lock()
+		got = append(got, s)
+		mu.Unlock()
+	}}
+	emitted := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), got...)
+	}
+
+	// a long multibyte line split mid-rune across reads comes out whole
+	long := "[rust] mallory: " + strings.Repeat("ż", 1000) + "\n"
+	lb.write(long[:1024])
+	lb.write(long[1024:1501])
+	if lines := lb.write(long[1501:] + "[rust] alice: hi\n"); len(lines) != 2 {
+		t.Fatalf("write returned %q", lines)
+	}
+	if e := emitted(); len(e) != 1 || e[0] != long+"[rust] alice: hi\n" {
+		t.Fatalf("emitted %q", e)
+	}
+
+	// an unfinished line, like the username prompt, is let through after a pause
+	lb.write("Please enter your username: ")
+	deadline := time.Now().Add(time.Second)
+	for len(emitted()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e := emitted(); len(e) != 2 || e[1] != "Please enter your username: " {
+		t.Fatalf("prompt not flushed: %q", e)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// how long to wait for the server's first byte when negotiating
+const compressHandshakeTimeout = 5 * time.Second
+
+// negotiateCompression asks the server for deflate. A server that agrees
+// echoes the hello; anything else is the start of a plain session.
+func negotiateCompression(c net.Conn) (net.Conn, error) {
+	if _, err := c.Write([]byte(protocol.CompressHello)); err != nil {
+		return nil, err
+	}
+	_ = c.SetReadDeadline(time.Now().Add(compressHandshakeTimeout))
+	var first [1]byte
+	_, err := c.Read(first[:])
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if string(first[:]) == protocol.CompressHello {
+		return protocol.NewFlateConn(c, c), nil
+	}
+	chatter("server does not support compression; continuing uncompressed")
+	return protocol.Replay(c, first[:]), nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chat-app-go/protocol"
+)
+
+// Terminal state shared by the server reader and the input loop.
+var (
+	displayMu    sync.Mutex
+	midLine      bool   // a partial server line is on screen; don't clear it
+	ephemeral    bool   // a typing notice occupies the bottom line
+	loggedIn     bool   // username sent; the prompt is only drawn after login
+	currentGroup string // tracked from the server's join/leave/rename replies
+	sessionToken string // from the welcome banner; kept across reconnects for /resume
+	composing    bool   // -multiline: continued lines are waiting for the last one
+	skipping     bool   // the last message was from an ignored user; drop its continuation lines too
+)
+
+// resetDisplay forgets per-session state before a (re)connect.
+func resetDisplay() {
+	displayMu.Lock()
+	midLine, ephemeral, loggedIn, currentGroup, composing, skipping = false, false, false, "", false, false
+	memberCount = -1
+	drawStatus()
+	displayMu.Unlock()
+}
+
+// trackGroup updates currentGroup from the server's confirmation messages.
+// Caller must hold displayMu.
+func trackGroup(line string) {
+	line = strings.TrimSuffix(line, "\n")
+	if g, ok := strings.CutPrefix(line, "Created group "); ok {
+		currentGroup = g
+	} else if g, ok := strings.CutPrefix(line, "Successfully joined group "); ok {
+		currentGroup = g
+	} else if rest, ok := strings.CutPrefix(line, "Session token: "); ok {
+		sessionToken, _, _ = strings.Cut(rest, " ")
+	} else if _, rest, ok := strings.Cut(line, "; you are back in "); ok && strings.HasPrefix(line, "Resumed your session as ") {
+		currentGroup = strings.TrimSuffix(rest, ".")
+	} else if strings.HasPrefix(line, "You have left the group ") {
+		currentGroup = ""
+	} else if rest, ok := strings.CutPrefix(line, "Group "+currentGroup+" was renamed to "); ok && currentGroup != "" {
+		if i := strings.LastIndex(rest, " by "); i >= 0 {
+			currentGroup = rest[:i]
+		}
+	}
+}
+
+// prompt shows where typed messages will go: "[rust]> " or "[global]> ".
+// Caller must hold displayMu.
+func prompt() string {
+	if composing {
+		return "...> "
+	}
+	if currentGroup == "" {
+		return "[global]> "
+	}
+	return "[" + currentGroup + "]> "
+}
+
+// drawPrompt puts the prompt back on the bottom line, unless a partial
+// server line or a typing notice is sitting there.
+// Caller must hold displayMu.
+func drawPrompt() {
+	if loggedIn && !raw && !midLine && !ephemeral {
+		fmt.Print(prompt())
+	}
+}
+
+// showPrompt redraws the prompt after the user sends a line. The first call
+// of a session (right after the username goes out) switches the prompt on.
+func showPrompt() {
+	displayMu.Lock()
+	loggedIn = true
+	drawPrompt()
+	displayMu.Unlock()
+}
+
+// handleClearCommand runs /clear locally: it wipes the screen, puts the
+// cursor top left and redraws the status line and prompt. It reports
+// whether line was /clear, in which case it must not be sent. On a
+// non-terminal stdout there is no screen to clear, so it just swallows the
+// command.
+func handleClearCommand(line string) bool {
+	if !strings.EqualFold(strings.TrimSpace(line), cmdPrefix+"clear") {
+		return false
+	}
+	if raw || !isTerminal(os.Stdout) {
+		return true
+	}
+	displayMu.Lock()
+	defer displayMu.Unlock()
+	fmt.Print("\x1b[2J\x1b[H")
+	midLine, ephemeral = false, false
+	drawStatus()
+	drawPrompt()
+	return true
+}
+
+// setComposing switches the continuation prompt on or off.
+func setComposing(on bool) {
+	displayMu.Lock()
+	composing = on
+	displayMu.Unlock()
+}
+
+// how many reads from the server may wait for a slow stdout before new ones
+// are dropped
+const printQueueSize = 1024
+
+// The printer decouples the socket from stdout: the reader hands each read
+// to queueIncoming and carries on, so a slow pipe on stdout can't stall the
+// connection until the server times it out.
+var (
+	printQueue  = make(chan printJob, printQueueSize)
+	printerDone = make(chan struct{})
+	dropped     atomic.Int64 // reads discarded since the last warning
+)
+
+// printJob is one read from the server, or with flushed set, a marker
+// that is signalled once everything queued before it has been printed.
+type printJob struct {
+	data    string
+	flushed chan struct{}
+}
+
+// startPrinter runs the goroutine that drains printQueue to stdout.
+func startPrinter() {
+	go func() {
+		defer close(printerDone)
+		for job := range printQueue {
+			if job.flushed != nil {
+				close(job.flushed)
+				continue
+			}
+			printIncoming(job.data)
+			if n := dropped.Swap(0); n > 0 {
+				chatter(fmt.Sprintf("output is too slow to keep up; dropped %d reads of server output", n))
+			}
+		}
+	}()
+}
+
+// flushPrinter waits for everything already queued to be printed, so the
+// state it tracks (group, session token) is current before a reconnect.
+func flushPrinter() {
+	flushed := make(chan struct{})
+	printQueue <- printJob{flushed: flushed}
+	<-flushed
+}
+
+// stopPrinter waits for everything already queued to be printed.
+func stopPrinter() {
+	close(printQueue)
+	<-printerDone
+}
+
+// queueIncoming passes server data to the printer without blocking,
+// dropping it if printQueueSize reads are already waiting.
+func queueIncoming(data string) {
+	select {
+	case printQueue <- printJob{data: data}:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// printIncoming writes server data to stdout, clearing the input line before
+// each new line and redrawing the prompt afterwards. Typing notices are drawn
+// without their newline so whatever arrives next overwrites them instead of
+// scrolling. With -raw, lines are written exactly as received; only the
+// client's own /ignore list still drops some.
+func printIncoming(data string) {
+	displayMu.Lock()
+	defer displayMu.Unlock()
+	defer drawPrompt()
+	defer drawStatus()
+
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if !trustServer && !raw {
+			line = protocol.StripControlLines(line)
+		}
+		if line == "" {
+			continue
+		}
+		if skipping = ignoredLine(line, skipping); skipping {
+			continue
+		}
+		if raw {
+			trackGroup(line)
+			os.Stdout.WriteString(line)
+			continue
+		}
+		if !midLine {
+			fmt.Print("\x1b[2K\r")
+		}
+		ephemeral = false
+		if rtt, ok := pongRTT(line); ok {
+			fmt.Printf("pong: round trip %s\n", rtt.Round(time.Microsecond))
+			midLine = false
+			continue
+		}
+		if strings.HasPrefix(line, protocol.TypingPrefix) && strings.HasSuffix(line, "\n") {
+			fmt.Print(strings.TrimSuffix(line, "\n"))
+			midLine, ephemeral = false, true
+			continue
+		}
+		group := currentGroup
+		trackGroup(line)
+		if currentGroup != group {
+			memberCount = -1
+		}
+		trackRoster(line)
+		os.Stdout.WriteString(colorize(line))
+		midLine = !strings.HasSuffix(line, "\n")
+	}
+}
+
+// stampPing turns a bare "/ping" into "/ping <unix nanos>" so the echoed
+// token tells us when the request left.
+func stampPing(line string) string {
+	if strings.EqualFold(strings.TrimSpace(line), cmdPrefix+"ping") {
+		return cmdPrefix + "ping " + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return line
+}
+
+// pongRTT recognises the server's echo of a stamped /ping.
+func pongRTT(line string) (time.Duration, bool) {
+	if !strings.HasPrefix(line, protocol.PongPrefix) || !strings.HasSuffix(line, "\n") {
+		return 0, false
+	}
+	sent, err := strconv.ParseInt(strings.TrimSpace(line[len(protocol.PongPrefix):]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, sent)), true
+}
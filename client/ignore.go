@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"chat-app-go/protocol"
+)
+
+// The ignore list lives only in this process: lines from ignored users are
+// dropped before printing, with no help from the server.
+var (
+	ignoreMu sync.Mutex
+	ignored  = make(map[string]bool)
+)
+
+// senderOf extracts the name from a chat line such as "[rust] alice: hi",
+// "[Global] alice: hi" or "[DM] alice: hi" (optionally marked as a mention
+// or a cross-post), and from typing notices. System messages and
+// announcements have no sender.
+func senderOf(line string) (string, bool) {
+	if strings.HasPrefix(line, protocol.AnnouncePrefix) {
+		return "", false
+	}
+	if rest, ok := strings.CutPrefix(line, protocol.TypingPrefix); ok {
+		name, found := strings.CutSuffix(strings.TrimRight(rest, "\n"), " is typing...")
+		return name, found
+	}
+	line = strings.TrimPrefix(line, protocol.MentionPrefix)
+	if !strings.HasPrefix(line, "[") {
+		return "", false
+	}
+	_, rest, ok := strings.Cut(line, "] ")
+	if !ok {
+		return "", false
+	}
+	name, _, ok := strings.Cut(strings.TrimPrefix(rest, protocol.CrossPost), ": ")
+	return name, ok
+}
+
+func isIgnored(line string) bool {
+	name, ok := senderOf(line)
+	if !ok {
+		return false
+	}
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+	return ignored[name]
+}
+
+// the server indents the second and later lines of a multi-line message
+// by this much under the sender's first line
+const continuationIndent = "    "
+
+// ignoredLine reports whether the ignore list hides line. A continuation
+// line has no sender of its own; it belongs to the message before it, and
+// prev is whether that message was hidden.
+func ignoredLine(line string, prev bool) bool {
+	if strings.HasPrefix(line, continuationIndent) {
+		return prev
+	}
+	return isIgnored(line)
+}
+
+// handleIgnoreCommand runs /ignore and /unignore locally. It reports whether
+// line was one of them, in which case it must not be sent to the server.
+func handleIgnoreCommand(line string) bool {
+	word, name, _ := strings.Cut(strings.TrimSpace(line), " ")
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(word) {
+	case cmdPrefix + "ignore":
+		ignoreMu.Lock()
+		defer ignoreMu.Unlock()
+		if name == "" {
+			names := make([]string, 0, len(ignored))
+			for n := range ignored {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			fmt.Println("Ignoring:", strings.Join(names, ", "))
+			return true
+		}
+		ignored[name] = true
+		fmt.Println("Ignoring " + name + " (" + cmdPrefix + "unignore " + name + " to undo)")
+		return true
+	case cmdPrefix + "unignore":
+		if name == "" {
+			fmt.Println("Usage: " + cmdPrefix + "unignore <name>")
+			return true
+		}
+		ignoreMu.Lock()
+		delete(ignored, name)
+		ignoreMu.Unlock()
+		fmt.Println("No longer ignoring " + name)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestSenderOf(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		ok   bool
+	}{
+		{"[rust] alice: hi\n", "alice", true},
+		{"[Global] alice: hi", "alice", true},
+		{"[DM] bob: psst\n", "bob", true},
+		{"[rust #42] alice: numbered\n", "alice", true},
+		{"[mention] [rust] alice: @bob look\n", "alice", true},
+		{"[rust] (cross-post) carol: hello\n", "carol", true},
+		{"[mention] [rust #7] (cross-post) carol: @bob hello\n", "carol", true},
+		{"[typing] alice is typing...\n", "alice", true},
+		{"[ANNOUNCEMENT] alice: maintenance at noon\n", "", false},
+		{"Created group rust\n", "", false},
+		{"    second line of a message\n", "", false},
+		{"[rust] alice joined the group\n", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := senderOf(tt.line)
+		if ok != tt.ok || (ok && name != tt.name) {
+			t.Errorf("senderOf(%q) = %q, %v; want %q, %v", tt.line, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	ignoreMu.Lock()
+	ignored = map[string]bool{"mallory": true}
+	ignoreMu.Unlock()
+	t.Cleanup(func() { ignored = make(map[string]bool) })
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"[rust] mallory: spam\n", true},
+		{"[DM] mallory: spam\n", true},
+		{"[rust] (cross-post) mallory: spam\n", true},
+		{"[typing] mallory is typing...\n", true},
+		{"[rust] alice: mallory: said what?\n", false},
+		{"[rust] malloryx: hi\n", false},
+		{"[ANNOUNCEMENT] mallory: hi\n", false},
+	}
+	for _, tt := range tests {
+		if got := isIgnored(tt.line); got != tt.want {
+			t.Errorf("isIgnored(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+
+	// continuation lines follow the message they belong to
+	skip := false
+	var shown []string
+	for _, line := range []string{
+		"[rust] mallory: one\n", "    two\n", "    three\n",
+		"[rust] alice: hi\n", "    there\n",
+	} {
+		if skip = ignoredLine(line, skip); !skip {
+			shown = append(shown, line)
+		}
+	}
+	if len(shown) != 2 || shown[0] != "[rust] alice: hi\n" || shown[1] != "    there\n" {
+		t.Fatalf("shown = %q", shown)
+	}
+}
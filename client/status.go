@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// Status line state, guarded by displayMu. statusRow is the terminal row the
+// status line occupies; 0 means it is off.
+var (
+	statusLine  bool // -status
+	statusRow   int
+	memberCount = -1 // members of currentGroup from the last roster; -1 if unknown
+)
+
+// terminalRows asks stty for the height of the controlling terminal.
+func terminalRows() int {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(string(out), &rows, &cols); err != nil {
+		return 0
+	}
+	return rows
+}
+
+// setupStatus reserves the bottom row of the terminal for the status line by
+// limiting scrolling to the rows above it, so incoming messages scroll past
+// without overwriting it. It does nothing without -status or a terminal,
+// or with -raw.
+func setupStatus() {
+	if !statusLine || raw {
+		return
+	}
+	rows := terminalRows()
+	if rows < 3 {
+		return
+	}
+	displayMu.Lock()
+	defer displayMu.Unlock()
+	statusRow = rows
+	fmt.Printf("\x1b[1;%dr\x1b[%d;1H", rows-1, rows-1)
+	drawStatus()
+}
+
+// teardownStatus gives the whole terminal back to the shell.
+func teardownStatus() {
+	displayMu.Lock()
+	defer displayMu.Unlock()
+	if statusRow == 0 {
+		return
+	}
+	fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K\x1b8\x1b[r", statusRow)
+	statusRow = 0
+}
+
+// drawStatus repaints the status line, leaving the cursor where it was.
+// Caller must hold displayMu.
+func drawStatus() {
+	if statusRow == 0 {
+		return
+	}
+	text := " global"
+	if currentGroup != "" {
+		text = " group: " + currentGroup
+		if memberCount >= 0 {
+			text += fmt.Sprintf(" | members: %d", memberCount)
+		}
+	}
+	if noColor {
+		fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K%s\x1b8", statusRow, text)
+		return
+	}
+	fmt.Printf("\x1b7\x1b[%d;1H\x1b[2K\x1b[7m%s \x1b[0m\x1b8", statusRow, text)
+}
+
+// trackRoster takes the member count of the current group from a roster
+// line such as "[roster] rust: alice, bob".
+// Caller must hold displayMu.
+func trackRoster(line string) {
+	rest, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), protocol.RosterPrefix)
+	if !ok || currentGroup == "" {
+		return
+	}
+	names, ok := strings.CutPrefix(rest, currentGroup+": ")
+	if !ok {
+		return
+	}
+	memberCount = 0
+	if names != "" {
+		memberCount = len(strings.Split(names, ", "))
+	}
+}
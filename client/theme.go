@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// A theme maps the roles below to SGR parameters such as "1;36" (bold
+// cyan). A role missing from the theme is printed plain.
+type theme map[string]string
+
+// roles a theme can color
+var themeRoles = []string{"nick", "system", "mention", "dm", "announce"}
+
+// built-in themes for -theme; the default only highlights announcements,
+// as the client always has
+var (
+	defaultTheme = theme{"announce": "1;33"}
+	themes       = map[string]theme{
+		"dark":  {"nick": "1;36", "system": "90", "mention": "1;35", "dm": "32", "announce": "1;33"},
+		"light": {"nick": "1;34", "system": "2", "mention": "1;31", "dm": "35", "announce": "1;31"},
+		"mono":  {"nick": "1", "system": "2", "mention": "7", "dm": "4", "announce": "1"},
+	}
+)
+
+// activeTheme is set once in main before any output; nil prints plain text.
+var activeTheme theme
+
+// noColor follows the NO_COLOR convention (https://no-color.org): when the
+// variable is set to anything non-empty, no colors or other text styling
+// are used, whatever -theme says. It is read once at startup.
+var noColor = os.Getenv("NO_COLOR") != ""
+
+// loadTheme resolves -theme: empty for the default, the name of a built-in
+// theme, or the path of a file with one "role = code" line per role ('#'
+// starts a comment). Codes may only hold digits and semicolons, so a theme
+// file can set colors but not send other escape sequences.
+func loadTheme(spec string) (theme, error) {
+	if spec == "" {
+		return defaultTheme, nil
+	}
+	if t, ok := themes[spec]; ok {
+		return t, nil
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a built-in theme (dark, light, mono) or a readable file: %w", spec, err)
+	}
+	defer f.Close()
+
+	t := theme{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		role, code, ok := strings.Cut(line, "=")
+		role, code = strings.TrimSpace(role), strings.TrimSpace(code)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s:%d: want role = code", spec, n)
+		case !isRole(role):
+			return nil, fmt.Errorf("%s:%d: unknown role %q (roles: %s)", spec, n, role, strings.Join(themeRoles, ", "))
+		case code == "" || strings.Trim(code, "0123456789;") != "":
+			return nil, fmt.Errorf("%s:%d: %q is not an SGR code such as 1;36", spec, n, code)
+		}
+		t[role] = code
+	}
+	return t, sc.Err()
+}
+
+func isRole(role string) bool {
+	for _, r := range themeRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminal reports whether f is a character device, which is as close as
+// the standard library gets to asking whether it is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in role's color from the active theme.
+func paint(role, s string) string {
+	code := activeTheme[role]
+	if code == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// colorize applies the active theme to one complete line from the server:
+// announcements, mentions and DMs are colored whole, chat lines get their
+// sender's name colored, and anything else from the server is a system
+// message. Partial lines and the indented continuation of a multi-line
+// message are left alone.
+func colorize(line string) string {
+	body, complete := strings.CutSuffix(line, "\n")
+	if !complete || len(activeTheme) == 0 || strings.HasPrefix(body, continuationIndent) {
+		return line
+	}
+	switch {
+	case strings.HasPrefix(body, protocol.AnnouncePrefix):
+		return paint("announce", body) + "\n"
+	case strings.HasPrefix(body, protocol.MentionPrefix):
+		return paint("mention", body) + "\n"
+	case strings.HasPrefix(body, protocol.DMPrefix):
+		return paint("dm", body) + "\n"
+	case strings.HasPrefix(body, protocol.RosterPrefix):
+		return paint("system", body) + "\n"
+	}
+	if name, ok := senderOf(body); ok && name != "" {
+		if i := strings.Index(body, "] "+name+": "); i >= 0 {
+			i += len("] ")
+			return body[:i] + paint("nick", name) + body[i+len(name):] + "\n"
+		}
+	}
+	return paint("system", body) + "\n"
+}
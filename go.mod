@@ -1,3 +1,5 @@
 module chat-app-go
 
 go 1.24.6
+
+require golang.org/x/crypto v0.39.0
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is used when an address is given without one.
+const DefaultPort = "8080"
+
+// NormalizeAddr turns user input into a host:port suitable for net.Listen
+// or net.Dial. It accepts "host:port", "[v6]:port", a bare host or IPv6
+// literal ("localhost", "::1", "[::1]") and a bare port ("9000"). Addresses
+// are always assembled with net.JoinHostPort so IPv6 literals get brackets.
+func NormalizeAddr(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, nil
+	}
+	if _, err := strconv.Atoi(addr); err == nil {
+		return net.JoinHostPort("", addr), nil
+	}
+	host := addr
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if host == "" || strings.ContainsAny(host, "[]") {
+		return "", fmt.Errorf("invalid address %q", addr)
+	}
+	return net.JoinHostPort(host, DefaultPort), nil
+}
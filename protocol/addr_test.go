@@ -0,0 +1,29 @@
+package protocol
+
+import "testing"
+
+func TestNormalizeAddr(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{":8080", ":8080"},
+		{"9000", ":9000"},
+		{"localhost", "localhost:8080"},
+		{"127.0.0.1:9000", "127.0.0.1:9000"},
+		{"::1", "[::1]:8080"},
+		{"[::1]", "[::1]:8080"},
+		{"[::1]:9000", "[::1]:9000"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:8080"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeAddr(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeAddr(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "[]", "[::1"} {
+		if got, err := NormalizeAddr(bad); err == nil {
+			t.Errorf("NormalizeAddr(%q) = %q, want error", bad, got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+	"sync"
+)
+
+// CompressHello is the negotiation byte. A client that wants compression
+// sends it before anything else; a server with compression enabled echoes
+// it back and both sides switch to deflate.
+const CompressHello = "\x1f"
+
+// flateConn carries a session over deflate streams. Every Write is flushed
+// so the peer can decode each message as soon as it arrives.
+type flateConn struct {
+	net.Conn
+	r io.Reader
+
+	mu sync.Mutex // a session may write from more than one goroutine
+	w  *flate.Writer
+}
+
+// NewFlateConn wraps c in deflate, reading the compressed stream from r
+// (usually c itself).
+func NewFlateConn(c net.Conn, r io.Reader) net.Conn {
+	w, _ := flate.NewWriter(c, flate.DefaultCompression) // only fails on a bad level
+	return &flateConn{Conn: c, r: flate.NewReader(r), w: w}
+}
+
+// Read reports a peer hanging up as io.EOF; the stream is never closed
+// with a final block, so flate sees that as io.ErrUnexpectedEOF.
+func (f *flateConn) Read(b []byte) (int, error) {
+	n, err := f.r.Read(b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *flateConn) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.w.Write(b)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	return n, err
+}
+
+// replayConn hands back bytes that were read during the handshake before
+// continuing with the connection itself.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (p *replayConn) Read(b []byte) (int, error) { return p.r.Read(b) }
+
+// Replay returns c with read handed back in front of it, for a handshake
+// that read bytes belonging to a plain session.
+func Replay(c net.Conn, read []byte) net.Conn {
+	return &replayConn{Conn: c, r: io.MultiReader(bytes.NewReader(read), c)}
+}
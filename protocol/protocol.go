@@ -0,0 +1,70 @@
+// Package protocol holds the parts of the line protocol that the server and
+// the client both depend on, so the two binaries can't drift apart.
+package protocol
+
+// Version numbers the line protocol. Bump it when a change needs client
+// support, e.g. a new framing, so older clients can be warned.
+const Version = 1
+
+// ClientHello is the optional first line from a client that reports its
+// build: "\x1dCLIENT <protocol> <version> [feature...]". Clients that don't
+// send one (telnet, nc, older builds) are simply not logged or warned.
+const ClientHello = "\x1dCLIENT "
+
+// MultilineFeature in the hello asks the server to treat a line ending in
+// a backslash as continued by the next one. Without it, a trailing
+// backslash is just part of the text.
+const MultilineFeature = "multiline"
+
+// UsernamePrompt asks a new connection for its name. It has no newline, so
+// clients watch for it to know when the login is waiting on them.
+const UsernamePrompt = "Please enter your username: "
+
+// Markers at the start of a server line that clients render specially.
+// Only the server starts a line with one: chat lines start with their
+// "[group] name:" label, and the server refuses group names that would
+// make that label read as a marker.
+const (
+	// TypingPrefix marks ephemeral typing notices so clients can render
+	// them without adding them to the transcript.
+	TypingPrefix = "[typing] "
+
+	// PongPrefix starts the reply to /ping; the rest of the line is the
+	// token the client sent, echoed verbatim so it can time the round trip.
+	PongPrefix = "[pong] "
+
+	// MentionPrefix marks the copy of a broadcast sent to a user it
+	// @mentions.
+	MentionPrefix = "[mention] "
+
+	// AnnouncePrefix starts an admin announcement so clients can
+	// highlight it.
+	AnnouncePrefix = "[ANNOUNCEMENT] "
+
+	// KickPrefix starts the notice a client removed by an admin hears
+	// before the server hangs up, so clients know not to reconnect.
+	KickPrefix = "[kicked] "
+
+	// RosterPrefix starts a periodic group roster line, e.g.
+	// "[roster] rust: alice, bob", for clients that keep a live member
+	// list.
+	RosterPrefix = "[roster] "
+
+	// DMPrefix starts a direct message: "[DM] alice: hi".
+	DMPrefix = "[DM] "
+)
+
+// CrossPost comes between a chat line's label and the sender's name when
+// the sender posted into a group they are not in: "[rust] (cross-post)
+// alice: hi".
+const CrossPost = "(cross-post) "
+
+// Markers lists every marker above, for checks that keep chat lines from
+// starting with one.
+var Markers = []string{TypingPrefix, PongPrefix, MentionPrefix, AnnouncePrefix, KickPrefix, RosterPrefix, DMPrefix}
+
+// KickNotice is what a removed client hears, followed by either "." or
+// ": <reason>". Clients match all of it rather than KickPrefix alone, so
+// ordinary chat in a "kicked" group on a server that allows one still
+// can't pass for it.
+const KickNotice = KickPrefix + "You have been disconnected by an admin"
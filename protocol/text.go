@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"strings"
+	"unicode"
+)
+
+// StripControl removes terminal escape sequences (CSI such as cursor moves
+// and screen clears, OSC such as title changes, and two-byte escapes) and
+// every other control character except tab, so relayed text can't take
+// over other users' terminals.
+func StripControl(s string) string {
+	var b strings.Builder
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r != 0x1b {
+			if r == '\t' || !unicode.IsControl(r) {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		if i+1 >= len(rs) {
+			break
+		}
+		i++
+		switch rs[i] {
+		case '[': // CSI: parameters, then one final byte in @..~
+			for i+1 < len(rs) && (rs[i+1] < 0x40 || rs[i+1] > 0x7e) {
+				i++
+			}
+			i++
+		case ']', 'P', 'X', '^', '_': // string sequences end with BEL or ESC \
+			for i+1 < len(rs) && rs[i+1] != 0x07 && rs[i+1] != 0x1b {
+				i++
+			}
+			i++
+			if i < len(rs) && rs[i] == 0x1b {
+				i++
+			}
+		}
+	}
+	return b.String()
+}
+
+// StripControlLines applies StripControl to each line of a multi-line
+// message, keeping the line breaks.
+func StripControlLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = StripControl(l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Continued reports whether line carries on into the next one: it ends in
+// an odd number of backslashes. The last of them is the marker; each pair
+// before it stands for one literal backslash, so "C:\\" is a complete line
+// ending in a backslash.
+func Continued(line string) bool {
+	return (len(line)-len(strings.TrimRight(line, `\`)))%2 == 1
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import "testing"
+
+func TestStripControl(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain text", "plain text"},
+		{"tab\tkept", "tab\tkept"},
+		{"héllo 日本語", "héllo 日本語"},
+		{"\x1b[2J\x1b[Hgotcha", "gotcha"},
+		{"\x1b[1;31mred\x1b[0m", "red"},
+		{"\x1b]0;pwned\x07title", "title"},
+		{"\x1b]0;pwned\x1b\\title", "title"},
+		{"\x1bcreset", "reset"},
+		{"bell\x07 back\bspace\r", "bell backspace"},
+		{"c1\u009b2Jx", "c12Jx"},
+		{"trailing\x1b", "trailing"},
+		{"\x1b[12;", ""},
+	}
+	for _, tt := range tests {
+		if got := StripControl(tt.in); got != tt.want {
+			t.Errorf("StripControl(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStripControlLines(t *testing.T) {
+	in := "one\x1b[2J\ntwo\r\n\x1b]0;pwned\x07three\n"
+	if got, want := StripControlLines(in), "one\ntwo\nthree\n"; got != want {
+		t.Errorf("StripControlLines(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestContinued(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"plain", false},
+		{`more\`, true},
+		{`C:\tmp\\`, false},
+		{`both\\\`, true},
+		{`\`, true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Continued(tt.line); got != tt.want {
+			t.Errorf("Continued(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
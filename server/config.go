@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"chat-app-go/protocol"
+)
+
+// restartOnly lists flags that are only read at startup. A reload that
+// changes one of them logs that a restart is needed and leaves it alone.
+var restartOnly = map[string]bool{
+	"addr":              true,
+	"unix":              true,
+	"presence-interval": true,
+	"peer":              true,
+	"node":              true,
+	"groups":            true,
+	"config":            true,
+	"admin-addr":        true,
+	"admin-token":       true,
+	"accounts":          true,
+}
+
+// setting is one "name = value" line of a config file.
+type setting struct {
+	line        int
+	name, value string
+}
+
+// readConfigFile parses a config file: one "name = value" per line, where
+// name is a server flag without its dash. Blank lines and lines starting
+// with # are skipped.
+func readConfigFile(path string) ([]setting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings []setting
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, n)
+		}
+		settings = append(settings, setting{n, strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+	return settings, sc.Err()
+}
+
+// applyConfigFile sets each flag named in the file. Flags given on the
+// command line win and are skipped. On reload, started is non-nil and
+// restart-only flags are compared instead of set, and a change is
+// reported. A file with an error changes nothing: flags already set from it
+// are put back. Func flags can't be put back this way; the ones outside
+// restartOnly only write cfg, which the caller restores.
+func applyConfigFile(path string, cmdline map[string]bool, started map[string]string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	restart := make(map[string][]string) // restart-only settings in the file
+	var names []string
+	for _, st := range settings {
+		f := flag.Lookup(st.name)
+		if f == nil {
+			rollback()
+			return fmt.Errorf("%s:%d: unknown setting %q", path, st.line, st.name)
+		}
+		if cmdline[st.name] {
+			continue
+		}
+		if started != nil && restartOnly[st.name] {
+			if restart[st.name] == nil {
+				names = append(names, st.name)
+			}
+			restart[st.name] = append(restart[st.name], st.value)
+			continue
+		}
+		if _, ok := f.Value.(flag.Getter); ok {
+			old := f.Value.String()
+			undo = append(undo, func() { _ = f.Value.Set(old) })
+		}
+		if err := f.Value.Set(st.value); err != nil {
+			rollback()
+			return fmt.Errorf("%s:%d: %s: %v", path, st.line, st.name, err)
+		}
+	}
+
+	var changed []string
+	for _, name := range names {
+		diff, err := restartChanged(name, restart[name], started)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		if diff {
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range changed {
+		fmt.Printf("config: %s changed to %q; requires restart\n", name, strings.Join(restart[name], ","))
+	}
+	return nil
+}
+
+// startedSettings records the restart-only flags whose String can't report
+// their value (flag.Func ones: addr and peer), as restartChanged compares
+// them: normalized addresses joined with commas.
+func startedSettings(addrs, peers []string) map[string]string {
+	return map[string]string{
+		"addr": strings.Join(addrs, ","),
+		"peer": strings.Join(peers, ","),
+	}
+}
+
+// restartChanged reports whether the file's values for the restart-only
+// flag name differ from what the server started with. Addresses are
+// normalized first; any other flag parses the last value through its own
+// Set and is then put back, so "1m" and "1m0s" compare equal.
+func restartChanged(name string, values []string, started map[string]string) (bool, error) {
+	if was, ok := started[name]; ok {
+		var addrs []string
+		for _, v := range values {
+			for _, a := range strings.Split(v, ",") {
+				addr, err := protocol.NormalizeAddr(strings.TrimSpace(a))
+				if err != nil {
+					return false, err
+				}
+				addrs = append(addrs, addr)
+			}
+		}
+		return strings.Join(addrs, ",") != was, nil
+	}
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	err := f.Value.Set(values[len(values)-1])
+	now := f.Value.String()
+	_ = f.Value.Set(old)
+	return now != old, err
+}
@@ -1,309 +1,233 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
-)
-
-type Client struct {
-	Name string
-	ID   int
-	Conn net.Conn
-}
+	"unicode"
 
-var (
-	lockClients    sync.Mutex
-	clientList     []Client                 // like vector<pair<string,int>> (name, id)
-	groupsToClient = make(map[string][]int) // group -> []clientID
-	clientToGroup  = make(map[int]string)   // clientID -> group
-	idToClient     = make(map[int]*Client)  // clientID -> ptr
-	serverListener net.Listener
-	nextClientID   = 1
+	"chat-app-go/chat"
+	"chat-app-go/protocol"
 )
 
-// remove int from slice, preserving order
-func removeIntFromSlice(a []int, x int) []int {
-	out := a[:0]
-	for _, v := range a {
-		if v != x {
-			out = append(out, v)
-		}
+// loadMOTD reads the message-of-the-day file. A missing or unreadable file
+// falls back to the built-in banner.
+func loadMOTD(path string) string {
+	if path == "" {
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("motd:", err, "- using the built-in banner")
+		return ""
 	}
-	return out
+	return string(b)
 }
 
-func closeClient(clientID int) {
-	lockClients.Lock()
-	defer lockClients.Unlock()
-
-	c := idToClient[clientID]
-	if c != nil && c.Conn != nil {
-		_ = c.Conn.Close()
+func main() {
+	cfg := chat.DefaultConfig()
+	configPath := flag.String("config", "", "file of \"flag = value\" lines applied under the command line; re-read on SIGHUP")
+	var addrs []string
+	flag.Func("addr", "address to listen on: host:port, [ipv6]:port, a bare host or a bare port; repeat it or give a comma-separated list to listen on several (default :"+protocol.DefaultPort+")", func(v string) error {
+		for _, a := range strings.Split(v, ",") {
+			addr, err := protocol.NormalizeAddr(strings.TrimSpace(a))
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, addr)
+		}
+		return nil
+	})
+	unixPath := flag.String("unix", "", "listen on this Unix socket path, instead of the default -addr or alongside any -addr given")
+	flag.IntVar(&cfg.MaxNameRunes, "maxname", cfg.MaxNameRunes, "maximum username length in characters")
+	flag.IntVar(&cfg.MaxMessageRunes, "maxmsg", cfg.MaxMessageRunes, "maximum chat message length in characters")
+	flag.BoolVar(&cfg.AllowGuests, "allow-guests", cfg.AllowGuests, "log in users who send a blank name as Guest<ID> instead of disconnecting them")
+	flag.BoolVar(&cfg.AnimalNames, "animal-names", cfg.AnimalNames, "log in users who send a blank name with a random name like BraveOtter (implies -allow-guests)")
+	flag.IntVar(&cfg.MaxGroupSize, "max-group-size", cfg.MaxGroupSize, "default maximum members per group; owners can override with /setlimit (0 = unlimited)")
+	flag.IntVar(&cfg.MaxGroups, "max-groups", cfg.MaxGroups, "maximum number of groups at once; empty groups are removed (0 = unlimited)")
+	flag.IntVar(&cfg.GroupCreateLimit, "group-create-limit", cfg.GroupCreateLimit, "groups one user may create per -group-create-window (0 = unlimited)")
+	flag.DurationVar(&cfg.GroupCreateWindow, "group-create-window", cfg.GroupCreateWindow, "window for -group-create-limit")
+	flag.BoolVar(&cfg.StrictGroups, "strict-groups", cfg.StrictGroups, "only allow joining the -groups list; users can't create or rename groups")
+	flag.BoolVar(&cfg.GroupsOnly, "groups-only", cfg.GroupsOnly, "turn off global chat: users must join a group before their chat lines go anywhere")
+	groups := flag.String("groups", "", "comma-separated groups created at startup and kept even when empty")
+	flag.IntVar(&cfg.HistorySize, "history", cfg.HistorySize, "chat lines kept per group for /export, /search and /reply (0 disables)")
+	flag.BoolVar(&cfg.MessageIDs, "message-ids", cfg.MessageIDs, "number chat lines ([rust #42] alice: hi) so users can /reply to them")
+	flag.StringVar(&cfg.ExportDir, "export-dir", cfg.ExportDir, "directory /export writes group history files to")
+	flag.BoolVar(&cfg.OwnerExport, "owner-export", cfg.OwnerExport, "let group owners /export their group, not just admins; anyone can create a group, so this lets any user write files to -export-dir")
+	flag.BoolVar(&cfg.StripControl, "strip-control", cfg.StripControl, "remove ANSI escape sequences and control characters from relayed messages (-strip-control=false for trusted deployments)")
+	flag.BoolVar(&cfg.Emoji, "emoji", cfg.Emoji, "expand shortcodes such as :smile: and :thumbsup: in chat lines to emoji")
+	flag.BoolVar(&cfg.Echo, "echo", cfg.Echo, "send each chat line back to its sender as well (users can change it with /echo)")
+	flag.BoolVar(&cfg.Compress, "compress", cfg.Compress, "deflate the stream for clients that also run with -compress")
+	flag.StringVar(&cfg.AdminPass, "admin-pass", "", "password for /auth, which unlocks /announce (default $CHAT_ADMIN_PASS; empty disables admin commands)")
+	accountsPath := flag.String("accounts", "", "enable /register and /login, keeping registered names and password hashes in this JSON file")
+	adminAddr := flag.String("admin-addr", "", "serve the JSON admin API (clients, groups, kick, announce) on this address")
+	adminToken := flag.String("admin-token", "", "bearer token required by the admin API (default $CHAT_ADMIN_TOKEN)")
+	hostname, _ := os.Hostname()
+	flag.StringVar(&cfg.NodeName, "node", hostname, "this server's name when linked to others; remote users show up as name@node")
+	flag.StringVar(&cfg.PeerSecret, "peer-secret", "", "shared secret for server links; incoming links are refused without it (default $CHAT_PEER_SECRET)")
+	var peers []string
+	flag.Func("peer", "link to the server at this address and relay chat both ways (repeatable)", func(v string) error {
+		addr, err := protocol.NormalizeAddr(v)
+		if err == nil {
+			peers = append(peers, addr)
+		}
+		return err
+	})
+	flag.Func("prefix", "character(s) that start a command instead of / (e.g. ! or .)", func(v string) error {
+		if v == "" || strings.ContainsFunc(v, unicode.IsSpace) {
+			return fmt.Errorf("prefix must be non-empty and contain no spaces")
+		}
+		cfg.CommandPrefix = v
+		return nil
+	})
+	motdPath := flag.String("motd", "", "file whose contents are sent to each user after login; re-read on SIGHUP")
+	flag.BoolVar(&cfg.MOTDReplacesHelp, "motd-replace", cfg.MOTDReplacesHelp, "send the -motd text instead of the command list rather than after it")
+	flag.BoolVar(&cfg.Acks, "ack", cfg.Acks, "tell senders whether each direct message was delivered")
+	flag.DurationVar(&cfg.AckTimeout, "ack-timeout", cfg.AckTimeout, "with -ack, how long to wait for a direct message to be written before telling the sender it is unconfirmed (0 waits as long as it takes)")
+	flag.IntVar(&cfg.OfflineQueue, "offline-queue", cfg.OfflineQueue, "hold up to this many /msg messages per offline user and deliver them at their next login (0 disables)")
+	flag.IntVar(&cfg.OfflineTotal, "offline-total", cfg.OfflineTotal, "hold at most this many /msg messages for all offline users together (0 = unlimited)")
+	flag.DurationVar(&cfg.OfflineTTL, "offline-ttl", cfg.OfflineTTL, "discard held messages older than this (0 keeps them until delivered)")
+	flag.DurationVar(&cfg.ResumeTTL, "resume-ttl", cfg.ResumeTTL, "give each login a token that /resume accepts for this long after a disconnect (0 disables)")
+	flag.DurationVar(&cfg.TypingInterval, "typing-interval", cfg.TypingInterval, "minimum gap between relayed typing notices from one client")
+	flag.DurationVar(&cfg.DedupWindow, "dedup-window", cfg.DedupWindow, "drop a chat line that repeats the sender's previous one within this long, e.g. 2s (0 disables)")
+	flag.DurationVar(&cfg.NickCooldown, "nick-cooldown", cfg.NickCooldown, "minimum gap between name changes with /nick from one client (0 disables)")
+	flag.DurationVar(&cfg.KeepAlive, "keepalive", cfg.KeepAlive, "TCP keepalive period for client connections (0 disables)")
+	flag.DurationVar(&cfg.LoginTimeout, "login-timeout", cfg.LoginTimeout, "disconnect a client that hasn't sent its username within this long (0 waits forever)")
+	flag.DurationVar(&cfg.ProbeInterval, "probe-interval", cfg.ProbeInterval, "after -keepalive of silence, probe the peer this often (0 uses -keepalive)")
+	flag.IntVar(&cfg.ProbeCount, "probe-count", cfg.ProbeCount, "drop a connection after this many unanswered probes (0 uses the OS default)")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "drop a client if a single write to it takes longer than this (0 disables)")
+	flag.DurationVar(&cfg.PresenceInterval, "presence-interval", cfg.PresenceInterval, "push changed group rosters to members this often (0 disables)")
+	flag.IntVar(&cfg.ReadBuffer, "read-buffer", cfg.ReadBuffer, "initial read buffer per connection in bytes; longer lines are still read whole, in several reads")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "on SIGINT or SIGTERM, wait this long for client connections to finish before exiting")
+	flag.IntVar(&cfg.QueueSize, "queue", cfg.QueueSize, "outbound messages buffered per client; a client that falls further behind is dropped")
+	showVersion := flag.Bool("version", false, "print the server version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("server", chat.Version)
+		return
 	}
 
-	// remove from clientList
-	for i := range clientList {
-		if clientList[i].ID == clientID {
-			clientList = append(clientList[:i], clientList[i+1:]...)
-			break
+	// flags given on the command line take precedence over the config file
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	if *configPath != "" {
+		if err := applyConfigFile(*configPath, cmdline, nil); err != nil {
+			log.Fatalf("config: %v", err)
 		}
 	}
-
-	// remove from group mappings
-	if grp, ok := clientToGroup[clientID]; ok {
-		groupsToClient[grp] = removeIntFromSlice(groupsToClient[grp], clientID)
-		delete(clientToGroup, clientID)
+	started := startedSettings(addrs, peers)
+	for _, g := range strings.Split(*groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			cfg.Groups = append(cfg.Groups, g)
+		}
 	}
-
-	delete(idToClient, clientID)
-}
-
-func sendTo(clientID int, msg string) error {
-	c := idToClient[clientID]
-	if c == nil || c.Conn == nil {
-		return fmt.Errorf("client missing")
+	if cfg.AdminPass == "" {
+		cfg.AdminPass = os.Getenv("CHAT_ADMIN_PASS")
 	}
-	_, err := c.Conn.Write([]byte(msg))
-	return err
-}
-
-func joinGroup(clientID int, raw string) int {
-	groupName := ""
-	if len(raw) >= 6 {
-		groupName = strings.TrimSpace(raw[6:])
+	if cfg.PeerSecret == "" {
+		cfg.PeerSecret = os.Getenv("CHAT_PEER_SECRET")
 	}
-	lockClients.Lock()
-	defer lockClients.Unlock()
-
-	msg := ""
-	if _, inGroup := clientToGroup[clientID]; inGroup {
-		msg = "You are already a part of a group."
-	} else {
-		if _, ok := groupsToClient[groupName]; !ok {
-			groupsToClient[groupName] = []int{}
-			msg = "Created group " + groupName
-		} else {
-			msg = "Successfully joined group " + groupName
-		}
-		groupsToClient[groupName] = append(groupsToClient[groupName], clientID)
-		clientToGroup[clientID] = groupName
+	if *adminToken == "" {
+		*adminToken = os.Getenv("CHAT_ADMIN_TOKEN")
 	}
-
-	msg += "\n"
-	if err := sendTo(clientID, msg); err != nil {
-		closeClient(clientID)
-		return -1
+	if *adminAddr != "" && *adminToken == "" {
+		log.Fatalf("config: -admin-addr needs -admin-token or $CHAT_ADMIN_TOKEN")
 	}
-	return 1
-}
-
-func getUsersList(clientID int) int {
-	lockClients.Lock()
-	defer lockClients.Unlock()
 
-	var usersList string
-	if _, ok := clientToGroup[clientID]; !ok {
-		usersList = "Connected Users:"
-		for j := 0; j < len(clientList); j++ {
-			usersList += "\n" + fmt.Sprintf("%d. %s", j+1, clientList[j].Name)
+	srv := chat.New(cfg)
+	srv.SetMOTD(loadMOTD(*motdPath))
+	if *accountsPath != "" {
+		if err := srv.LoadAccounts(*accountsPath); err != nil {
+			log.Fatalf("accounts: %v", err)
 		}
-	} else {
-		groupName := clientToGroup[clientID]
-		usersList = "Users connected to " + groupName + ":"
-		for j := 0; j < len(groupsToClient[groupName]); j++ {
-			id := groupsToClient[groupName][j]
-			clientName := ""
-			for k := 0; k < len(clientList); k++ {
-				if clientList[k].ID == id {
-					clientName = clientList[k].Name
-					break
+	}
+
+	// SIGHUP re-reads the config file and MOTD without dropping anyone
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if *configPath != "" {
+				saved := cfg
+				if err := applyConfigFile(*configPath, cmdline, started); err != nil {
+					cfg = saved
+					fmt.Println("config:", err, "- keeping the current settings")
+					continue
 				}
+				srv.Reload(cfg)
 			}
-			usersList += "\n" + fmt.Sprintf("%d. %s", j+1, clientName)
+			srv.SetMOTD(loadMOTD(*motdPath))
+			fmt.Println("reloaded configuration")
 		}
-	}
-	usersList += "\n"
+	}()
 
-	if err := sendTo(clientID, usersList); err != nil {
-		closeClient(clientID)
-		return -1
-	}
-	return 1
-}
+	// SIGINT (Ctrl-C) and SIGTERM (kill, systemd, docker stop) shut down the same way
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func clientRoutine(clientID int) {
-	c := idToClient[clientID]
-	if c == nil || c.Conn == nil {
-		closeClient(clientID)
-		return
+	if len(addrs) == 0 && *unixPath == "" {
+		addrs = []string{":" + protocol.DefaultPort}
 	}
-
-	ask := "Please enter your username: "
-	if _, err := c.Conn.Write([]byte(ask)); err != nil {
-		closeClient(clientID)
-		return
+	// try every address so one run reports all that fail, and exit
+	// non-zero so a supervisor can tell the server never came up
+	var lns []net.Listener
+	var listenErrs []error
+	listen := func(network, addr string) {
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			listenErrs = append(listenErrs, fmt.Errorf("cannot listen on %s: %w", addr, err))
+			return
+		}
+		lns = append(lns, ln)
 	}
-
-	// First recv = username chunk (like C++: single recv(), not line-based)
-	buf := make([]byte, 1024)
-	n, err := c.Conn.Read(buf)
-	if err != nil || n <= 0 {
-		// mimic perror + close in C++
-		closeClient(clientID)
-		return
+	for _, a := range addrs {
+		listen("tcp", a)
 	}
-	clientName := string(buf[:n])
-
-	lockClients.Lock()
-	c.Name = clientName
-	clientList = append(clientList, Client{Name: clientName, ID: clientID, Conn: c.Conn})
-	fmt.Println(clientName)
-	lockClients.Unlock()
-
-	welcome := "Welcome " + clientName + "! You can use the following commands:\n" +
-		"/users - List all connected users\n" +
-		"/join <group_name> - Join a group\n" +
-		"/groups - List all available groups\n" +
-		"/leave - Leave the current group\n"
-	if _, err := c.Conn.Write([]byte(welcome)); err != nil {
-		closeClient(clientID)
-		return
+	if *unixPath != "" {
+		listen("unix", *unixPath)
 	}
-
-	// Main recv loop: treat each Read() chunk as a message (like C++ recv)
-	for {
-		n, err := c.Conn.Read(buf)
-		if err != nil || n <= 0 {
-			// received <=0 : close and exit
-			closeClient(clientID)
-			return
-		}
-		temp := string(buf[:n])
-
-		switch {
-		case strings.HasPrefix(temp, "/users"):
-			if getUsersList(clientID) < 0 {
-				return
-			}
-		case strings.HasPrefix(temp, "/join"):
-			if joinGroup(clientID, temp) < 0 {
-				return
-			}
-		case strings.HasPrefix(temp, "/groups"):
-			lockClients.Lock()
-			groupsList := "Available Groups:"
-			for grp, ids := range groupsToClient {
-				groupsList += "\n" + grp + " (" + fmt.Sprintf("%d", len(ids)) + " user/s)"
-			}
-			groupsList += "\n"
-			lockClients.Unlock()
-			if err := sendTo(clientID, groupsList); err != nil {
-				closeClient(clientID)
-				return
-			}
-		case strings.HasPrefix(temp, "/leave"):
-			lockClients.Lock()
-			if grp, ok := clientToGroup[clientID]; ok {
-				groupsToClient[grp] = removeIntFromSlice(groupsToClient[grp], clientID)
-				delete(clientToGroup, clientID)
-				lockClients.Unlock()
-
-				msg := "You have left the group " + grp + "\n"
-				if err := sendTo(clientID, msg); err != nil {
-					closeClient(clientID)
-					return
-				}
-			} else {
-				lockClients.Unlock()
-				msg := "You are not part of any group.\n"
-				if err := sendTo(clientID, msg); err != nil {
-					closeClient(clientID)
-					return
-				}
-			}
-		default:
-			// Broadcast: group or global
-			lockClients.Lock()
-			name := c.Name
-			if grp, ok := clientToGroup[clientID]; ok {
-				out := "[" + grp + "] " + name + ": " + temp
-				recipients := append([]int(nil), groupsToClient[grp]...)
-				lockClients.Unlock()
-
-				for _, id := range recipients {
-					if id == clientID {
-						continue
-					}
-					if err := sendTo(id, out); err != nil {
-						closeClient(id)
-					}
-				}
-			} else {
-				out := "[Global] " + name + ": " + temp
-				recipients := make([]int, 0, len(clientList))
-				for _, meta := range clientList {
-					recipients = append(recipients, meta.ID)
-				}
-				lockClients.Unlock()
-
-				for _, id := range recipients {
-					if id == clientID {
-						continue
-					}
-					if err := sendTo(id, out); err != nil {
-						closeClient(id)
-					}
-				}
-			}
+	if len(listenErrs) > 0 {
+		for _, ln := range lns {
+			_ = ln.Close()
 		}
+		log.Fatal(errors.Join(listenErrs...))
 	}
-}
-
-func main() {
-	// SIGINT handling (Ctrl-C)
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGINT)
-	go func() {
-		<-sigc
-		fmt.Print("Detected exit")
-		if serverListener != nil {
-			_ = serverListener.Close()
-		}
-		lockClients.Lock()
-		for _, c := range idToClient {
-			if c.Conn != nil {
-				_ = c.Conn.Close()
-			}
-		}
-		lockClients.Unlock()
-		os.Exit(0)
-	}()
-
-	ln, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		fmt.Println("listen failed:", err)
-		return
+	if *unixPath != "" {
+		defer os.Remove(*unixPath)
+	}
+	for _, ln := range lns {
+		fmt.Println("listening on", ln.Addr())
 	}
-	serverListener = ln
 
-	for {
-		conn, err := ln.Accept()
+	if *adminAddr != "" {
+		addr, err := protocol.NormalizeAddr(*adminAddr)
 		if err != nil {
-			// likely listener closed on SIGINT
-			return
+			log.Fatalf("cannot listen: %v", err)
 		}
+		adminLn, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("cannot listen on %s: %v", addr, err)
+		}
+		adminSrv := &http.Server{Handler: srv.AdminHandler(*adminToken)}
+		defer adminSrv.Close()
+		go func() { _ = adminSrv.Serve(adminLn) }()
+	}
 
-		lockClients.Lock()
-		myID := nextClientID
-		nextClientID++
-		idToClient[myID] = &Client{Name: "", ID: myID, Conn: conn}
-		lockClients.Unlock()
-
-		go clientRoutine(myID)
+	for _, p := range peers {
+		go srv.ConnectPeer(p)
+	}
+	if err := srv.Run(ctx, lns...); err != nil {
+		fmt.Println(err)
+	}
+	if ctx.Err() != nil {
+		fmt.Println("Detected exit")
 	}
 }
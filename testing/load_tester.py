@@ -99,9 +99,10 @@ class Client:
 
         uname = f"bot_{self.idx}"
         try:
-            self.writer.write(uname.encode())   # no newline; matches your server behavior
+            line = (uname + "\n").encode()    # server reads newline-delimited messages
+            self.writer.write(line)
             await self.writer.drain()
-            self.metrics.bytes_sent += len(uname)
+            self.metrics.bytes_sent += len(line)
         except Exception:
             self.running = False
 
@@ -136,10 +137,10 @@ class Client:
             now = time.time()
             if now >= next_send:
                 try:
-                    # Send /users command (no newline)
-                    self.writer.write(b"/users")
+                    # Send /users command
+                    self.writer.write(b"/users\n")
                     await self.writer.drain()
-                    self.metrics.bytes_sent += len(b"/users")
+                    self.metrics.bytes_sent += len(b"/users\n")
                     self.metrics.requests_sent += 1
                     # Start latency probe: TTFB measured on next read arrival
                     self.pending_probe_started_at = time.time()